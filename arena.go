@@ -0,0 +1,141 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+// arena packs the keys and values of a tree into large byte chunks, so that
+// the garbage collector sees one object per chunk rather than one per key and
+// one per value.
+//
+// Nodes still hold keys and values as slices into the chunks, so the collector
+// still follows two pointers per item, but it marks a few large objects
+// instead of millions of small ones, which is where most of its time went.
+// Referring to them by offset instead would take a second layout of every
+// node, and a chunk lookup on every key comparison and every key or value
+// handed out.
+//
+// Chunks are append-only: bytes handed out are never written again, which lets
+// clones keep sharing them.  Each tree has its own arena, and a clone starts
+// a new chunk rather than appending to the one it was cloned with.
+//
+// Removing an item from the tree leaves its bytes dead in their chunk.  The
+// arena keeps count of live and dead bytes, and once dead bytes outnumber live
+// ones (and fill at least a chunk), the tree copies its items into a new arena
+// and lets the old chunks go.
+type arena struct {
+	chunkSize int
+	chunk     []byte // unused tail of the current chunk
+	live      int    // bytes held by items in the tree
+	dead      int    // bytes held by items removed from the tree
+}
+
+func newArena(chunkSize int) *arena {
+	return &arena{chunkSize: chunkSize}
+}
+
+// fork returns a copy of a for a clone of its tree.
+func (a *arena) fork() *arena {
+	out := *a
+	out.chunk = nil
+	return &out
+}
+
+// alloc returns n bytes that nothing else references.  Requests of more than
+// a quarter of a chunk get their own allocation, so that they don't waste the
+// rest of the current chunk.
+func (a *arena) alloc(n int) []byte {
+	a.live += n
+	if n > a.chunkSize/4 {
+		return make([]byte, n)
+	}
+	if n > len(a.chunk) || a.chunk == nil {
+		a.chunk = make([]byte, a.chunkSize)
+	}
+	b := a.chunk[:n:n]
+	a.chunk = a.chunk[n:]
+	return b
+}
+
+// own copies k and v into the arena, as copyOnWriteContext.own does.
+func (a *arena) own(k, v []byte) ([]byte, []byte) {
+	buf := a.alloc(len(k) + len(v))
+	copy(buf, k)
+	ck := buf[:len(k):len(k)]
+	if v == nil {
+		return ck, nil
+	}
+	copy(buf[len(k):], v)
+	return ck, buf[len(k):]
+}
+
+// free records that item has left the tree.
+func (a *arena) free(item Item) {
+	n := len(item[0]) + len(item[1])
+	a.live -= n
+	a.dead += n
+}
+
+// shouldCompact reports whether enough bytes are dead to be worth copying the
+// live ones elsewhere.
+func (a *arena) shouldCompact() bool {
+	return a.dead > a.live && a.dead >= a.chunkSize
+}
+
+// release records that the items of the subtree rooted at n have left the
+// tree, if it uses an arena.
+func (c *copyOnWriteContext) release(n *node) {
+	if c.arena == nil {
+		return
+	}
+	for _, it := range n.items {
+		c.arena.free(it)
+	}
+	for _, child := range n.children {
+		c.release(child)
+	}
+}
+
+// loadArena returns the arena that should hold the items of a tree rebuilt
+// from scratch, e.g. by BulkLoad, or nil if the tree doesn't use one.
+func (c *copyOnWriteContext) loadArena() *arena {
+	if c.arena == nil {
+		return nil
+	}
+	return newArena(c.arena.chunkSize)
+}
+
+// maybeCompact moves the items of t into a new arena if too much of the
+// current one is dead.
+func (t *BTree) maybeCompact() {
+	if t.cow.arena == nil || !t.cow.arena.shouldCompact() {
+		return
+	}
+	a := newArena(t.cow.arena.chunkSize)
+	if t.root != nil {
+		t.root = t.root.mutableFor(t.cow)
+		t.root.compact(a)
+	}
+	t.cow.arena = a
+}
+
+// compact copies the keys and values of the subtree into a.
+func (n *node) compact(a *arena) {
+	for i, it := range n.items {
+		k, v := a.own(it[0], it[1])
+		n.items[i] = Item{k, v}
+	}
+	for i := range n.children {
+		n.mutableChild(i).compact(a)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestArena(t *testing.T) {
+	const size = 2000
+	tr := NewWithOptions(Options{Degree: 3, ArenaChunkSize: 256})
+	buf := make([]byte, 8)
+	for i := 0; i < size; i++ {
+		copy(buf, seqKey(i))
+		copy(buf[4:], seqKey(-i))
+		tr.ReplaceOrInsert(buf[:4], buf[4:])
+	}
+	check := func(from, to int) {
+		t.Helper()
+		checkSeq(t, tr, from, to)
+		for i := from; i < to; i++ {
+			if v, ok := tr.Get(seqKey(i)); !ok || !bytes.Equal(v, seqKey(-i)) {
+				t.Fatalf("key %d: got %x, %v", i, v, ok)
+			}
+		}
+		if a := tr.cow.arena; a.live != 8*(to-from) || a.shouldCompact() {
+			t.Fatalf("arena holds %d live and %d dead bytes for %d items", a.live, a.dead, to-from)
+		}
+	}
+	check(0, size)
+
+	clone := tr.Clone()
+	for i := 0; i < size/2; i++ {
+		tr.Delete(seqKey(i))
+	}
+	tr.DeleteRange(seqKey(size/2), seqKey(3*size/4))
+	check(3*size/4, size)
+	checkSeq(t, clone, 0, size)
+	for i := 0; i < size; i++ {
+		if v, ok := clone.Get(seqKey(i)); !ok || !bytes.Equal(v, seqKey(-i)) {
+			t.Fatalf("clone key %d: got %x, %v", i, v, ok)
+		}
+	}
+
+	keys, values := rang(100)
+	if err := tr.BulkLoad(sliceIter(keys, values)); err != nil {
+		t.Fatal(err)
+	}
+	got, gotValues := all(tr)
+	if !reflect.DeepEqual(got, keys) || !reflect.DeepEqual(gotValues, values) {
+		t.Fatalf("bulk load mismatch")
+	}
+	if live := tr.cow.arena.live; live != 100*(20+32) {
+		t.Fatalf("arena holds %d live bytes after bulk load", live)
+	}
+	tr.Clear(false)
+	if a := tr.cow.arena; a.live != 0 || a.dead != 0 {
+		t.Fatalf("arena holds %d live and %d dead bytes after clear", a.live, a.dead)
+	}
+}
+
+func BenchmarkInsertArena(b *testing.B) {
+	const size = 1_000_000
+	// Keys and values are cut from a single buffer, so that the collections
+	// timed below only have the tree to mark.
+	const keyLen, valueLen = 20, 32
+	buf := make([]byte, size*(keyLen+valueLen))
+	rand.Read(buf)
+	item := func(j int) ([]byte, []byte) {
+		it := buf[j*(keyLen+valueLen):]
+		return it[:keyLen:keyLen], it[keyLen : keyLen+valueLen : keyLen+valueLen]
+	}
+	for _, bc := range []struct {
+		name string
+		opts Options
+	}{
+		{"Heap", Options{CopyItems: true}},
+		{"Arena", Options{ArenaChunkSize: 1 << 20}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			b.ReportAllocs()
+			b.ResetTimer()
+			var tr *BTree
+			for i := 0; i < b.N; i++ {
+				tr = NewWithOptions(bc.opts)
+				for j := 0; j < size; j++ {
+					tr.ReplaceOrInsert(item(j))
+				}
+			}
+			b.StopTimer()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "gc-pause-ns/op")
+			// Time full collections with the last tree still alive.
+			const collections = 5
+			start := time.Now()
+			for i := 0; i < collections; i++ {
+				runtime.GC()
+			}
+			b.ReportMetric(float64(time.Since(start).Microseconds())/collections, "gc-µs")
+			runtime.KeepAlive(tr)
+		})
+	}
+}
@@ -15,7 +15,8 @@
 // Package btree implements in-memory B-Trees of arbitrary degree.
 //
 // btree implements an in-memory B-Tree for use as an ordered data structure.
-// It is not meant for persistent storage solutions.
+// It is not meant for persistent storage solutions, though a tree can be saved
+// to a stream and loaded back with WriteTo and ReadFrom.
 //
 // It has a flatter structure than an equivalent red-black or other binary tree,
 // which in some cases yields better memory usage and/or performance.
@@ -55,7 +56,12 @@ import (
 	"sync"
 )
 
+// Degree is the node degree of trees created by New and NewWithFreeList.
+// Use NewWithOptions to build a tree with a different degree.
 const Degree = 128
+
+// MaxItems and MinItems are the per-node item bounds of a tree of the
+// default Degree.
 const MaxItems = Degree*2 - 1
 const MinItems = Degree - 1
 
@@ -72,6 +78,14 @@ type Item [2][]byte
 
 func (it *Item) Less(than *Item) bool { return bytes.Compare(it[0], than[0]) < 0 }
 
+// CompareFunc orders keys in a tree.  It returns a negative number when a
+// sorts before b, zero when they are the same key and a positive number when
+// a sorts after b.
+//
+// It must provide a strict weak ordering and must not change while a tree
+// that uses it holds items.
+type CompareFunc func(a, b []byte) int
+
 const (
 	DefaultFreeListSize = 32
 )
@@ -122,33 +136,121 @@ func (f *FreeList) freeNode(n *node) (out bool) {
 	return
 }
 
+// PathHint remembers the path taken by a previous descent into a tree, and
+// is used by the *Hint methods to speed up operations on keys that sit close
+// to each other, such as monotonically increasing keys.
+//
+// A hint is only ever a guess: it is verified against the keys found along
+// the way, and a stale hint, e.g. after splits or merges, falls back to a
+// normal binary search.  The zero value is ready to use.  A PathHint must not
+// be shared by concurrent calls.
+type PathHint struct {
+	used [16]bool
+	path [16]int32
+}
+
 // ItemIterator allows callers of Ascend* to iterate in-order over portions of
 // the tree.  When this function returns false, iteration will stop and the
 // associated Ascend* function will immediately return.
 type ItemIterator func(k, v []byte) bool
 
-// New creates a new B-Tree with the given degree.
-//
-// New(2), for example, will create a 2-3-4 tree (each node contains 1-3 items
-// and 2-4 children).
+// Options configures a tree created by NewWithOptions.  The zero value of
+// every field selects the default.
+type Options struct {
+	// Degree is the node degree of the tree.
+	//
+	// Degree 2, for example, will create a 2-3-4 tree (each node contains 1-3
+	// items and 2-4 children).  Zero means Degree.
+	Degree int
+	// FreeList is the node free list used by the tree.  If nil, the tree gets
+	// its own list of DefaultFreeListSize.
+	FreeList *FreeList
+	// Compare orders the keys of the tree.  If nil, keys are ordered by
+	// bytes.Compare.  Clones share the comparator of the tree they were
+	// cloned from.  The prefix operations, such as AscendPrefix, rely on the
+	// default order and panic on a tree with a Compare.
+	Compare CompareFunc
+	// CopyItems makes the tree store its own copy of every key and value
+	// added by ReplaceOrInsert or BulkLoad, so that callers are free to reuse
+	// their buffers once the call returns.  Clones inherit the setting.
+	CopyItems bool
+	// ArenaChunkSize, if positive, makes the tree store its own copy of every
+	// key and value, as CopyItems does, packed into chunks of this many bytes
+	// instead of allocated one by one.  This cuts the number of heap objects,
+	// and so the work of the garbage collector, for trees of many small items.
+	//
+	// The space of removed items is reclaimed by copying the remaining items
+	// into new chunks once it outgrows them.  Clones inherit the setting.
+	ArenaChunkSize int
+	// CompressKeys makes every leaf store the prefix shared by its keys once,
+	// and only the rest of each key per item, which saves memory when keys
+	// share long prefixes.  Keys are rebuilt when they are handed out, so
+	// reads that return keys, such as iteration, allocate, and so does
+	// removing an item.  Leaves are repacked when they split or merge, or
+	// once enough keys were inserted into them.
+	//
+	// CompressKeys relies on the default ordering of keys: it cannot be
+	// combined with Compare, nor with ArenaChunkSize.
+	CompressKeys bool
+}
+
+// New creates a new B-Tree of the default Degree.
 func New() *BTree {
 	return NewWithFreeList(NewFreeList(DefaultFreeListSize))
 }
 
 // NewWithFreeList creates a new B-Tree that uses the given node free list.
 func NewWithFreeList(f *FreeList) *BTree {
+	return NewWithOptions(Options{FreeList: f})
+}
+
+// NewWithOptions creates a new B-Tree configured by opts.
+//
+// It panics if opts.Degree is less than 2 (and not zero), or if opts combines
+// CompressKeys with an option it doesn't support.
+func NewWithOptions(opts Options) *BTree {
+	degree := opts.Degree
+	if degree == 0 {
+		degree = Degree
+	}
+	if degree < 2 {
+		panic("bad degree")
+	}
+	if opts.CompressKeys && (opts.Compare != nil || opts.ArenaChunkSize > 0) {
+		panic("CompressKeys cannot be combined with Compare or ArenaChunkSize")
+	}
+	f := opts.FreeList
+	if f == nil {
+		f = NewFreeList(DefaultFreeListSize)
+	}
+	compare := opts.Compare
+	if compare == nil {
+		compare = bytes.Compare
+	}
+	cow := &copyOnWriteContext{
+		freelist:      f,
+		compare:       compare,
+		customCompare: opts.Compare != nil,
+		copyItems:     opts.CopyItems,
+		compress:      opts.CompressKeys,
+	}
+	if opts.ArenaChunkSize > 0 {
+		cow.arena = newArena(opts.ArenaChunkSize)
+	}
 	return &BTree{
-		cow: &copyOnWriteContext{freelist: f},
+		degree: degree,
+		cow:    cow,
 	}
 }
 
-// items stores items in a node.
-type items []*Item
+// items stores items in a node.  Items are stored by value, so a node holds
+// its keys and values directly rather than pointers to them.
+type items []Item
 
 // insertAt inserts a value into the given index, pushing all subsequent values
 // forward.
-func (s *items) insertAt(index int, item *Item) {
-	*s = append(*s, nil)
+func (s *items) insertAt(index int, item Item) {
+	*s = append(*s, Item{})
 	if index < len(*s) {
 		copy((*s)[index+1:], (*s)[index:])
 	}
@@ -157,19 +259,19 @@ func (s *items) insertAt(index int, item *Item) {
 
 // removeAt removes a value at a given index, pulling all subsequent values
 // back.
-func (s *items) removeAt(index int) *Item {
+func (s *items) removeAt(index int) Item {
 	item := (*s)[index]
 	copy((*s)[index:], (*s)[index+1:])
-	(*s)[len(*s)-1] = nil
+	(*s)[len(*s)-1] = Item{}
 	*s = (*s)[:len(*s)-1]
 	return item
 }
 
 // pop removes and returns the last element in the list.
-func (s *items) pop() (out *Item) {
+func (s *items) pop() (out Item) {
 	index := len(*s) - 1
 	out = (*s)[index]
-	(*s)[index] = nil
+	(*s)[index] = Item{}
 	*s = (*s)[:index]
 	return
 }
@@ -187,26 +289,64 @@ func (s *items) truncate(index int) {
 // find returns the index where the given item should be inserted into this
 // list.  'found' is true if the item already exists in the list at the given
 // index.
-func (s items) find(item *Item) (index int, found bool) {
+func (s items) find(item *Item, compare CompareFunc) (index int, found bool) {
 	//inline sort.Search
 	//i := sort.Search(len(s), func(i int) bool { return item.Less(s[i]) })
 	i, j := 0, len(s)
 	for i < j {
 		h := int(uint(i+j) >> 1) // avoid overflow when computing h
 		// i ≤ h < j
-		if bytes.Compare(item[0], s[h][0]) >= 0 {
+		if compare(item[0], s[h][0]) >= 0 {
 			i = h + 1 // preserves f(i-1) == false
 		} else {
 			j = h // preserves f(j) == true
 		}
 	}
 
-	if i > 0 && !s[i-1].Less(item) {
+	if i > 0 && compare(s[i-1][0], item[0]) >= 0 {
 		return i - 1, true
 	}
 	return i, false
 }
 
+// findHint is find, starting from the guess that item belongs at index i.
+// The guess is checked against the items around i, so a wrong guess costs a
+// couple of comparisons before falling back to a binary search.
+func (s items) findHint(item *Item, compare CompareFunc, i int) (index int, found bool) {
+	if i > len(s) {
+		i = len(s)
+	}
+	if i < len(s) {
+		switch c := compare(item[0], s[i][0]); {
+		case c == 0:
+			return i, true
+		case c > 0:
+			// Most often, item sorts right after the hinted one.
+			if i+1 == len(s) {
+				return i + 1, false
+			}
+			switch c := compare(item[0], s[i+1][0]); {
+			case c == 0:
+				return i + 1, true
+			case c < 0:
+				return i + 1, false
+			}
+			return s.find(item, compare)
+		}
+	}
+	// item sorts before s[i]: it belongs at i if it sorts after s[i-1].
+	if i == 0 {
+		return 0, false
+	}
+	switch c := compare(item[0], s[i-1][0]); {
+	case c == 0:
+		return i - 1, true
+	case c > 0:
+		return i, false
+	}
+	return s.find(item, compare)
+}
+
 // children stores child nodes in a node.
 type children []*node
 
@@ -254,10 +394,17 @@ func (s *children) truncate(index int) {
 // It must at all times maintain the invariant that either
 //   * len(children) == 0, len(items) unconstrained
 //   * len(children) == len(items) + 1
+//
+// count is the number of items in the subtree rooted at the node.  prefix is
+// non-nil if the node is a packed leaf, and loose the number of keys inserted
+// since it was packed (see compress.go).
 type node struct {
 	cow      *copyOnWriteContext
 	items    items
 	children children
+	count    int
+	prefix   []byte
+	loose    int
 }
 
 func (n *node) mutableFor(cow *copyOnWriteContext) *node {
@@ -278,9 +425,24 @@ func (n *node) mutableFor(cow *copyOnWriteContext) *node {
 		out.children = make(children, len(n.children), cap(n.children))
 	}
 	copy(out.children, n.children)
+	out.count = n.count
+	if n.isPacked() {
+		// The copy shares the packed keys of n, which are never modified.
+		out.prefix, out.loose = n.prefix, n.loose
+		cow.forgetDirty(out)
+	}
 	return out
 }
 
+// recount recomputes n.count from its items and the counts of its children.
+func (n *node) recount() {
+	count := len(n.items)
+	for _, c := range n.children {
+		count += c.count
+	}
+	n.count = count
+}
+
 func (n *node) mutableChild(i int) *node {
 	c := n.children[i].mutableFor(n.cow)
 	n.children[i] = c
@@ -290,99 +452,187 @@ func (n *node) mutableChild(i int) *node {
 // split splits the given node at the given index.  The current node shrinks,
 // and this function returns the item that existed at that index and a new node
 // containing all items/children after it.
-func (n *node) split(i int) (*Item, *node) {
+func (n *node) split(i int) (Item, *node) {
 	item := n.items[i]
 	next := n.cow.newNode()
+	if len(n.children) == 0 {
+		item = n.takeItem(i)
+		if n.isPacked() {
+			// Both halves keep the prefix, and are packed again once the
+			// write is done, in case their keys share more of it.
+			next.prefix = n.prefix
+			n.cow.dirty = append(n.cow.dirty, n)
+		}
+	}
 	next.items = append(next.items, n.items[i+1:]...)
 	n.items.truncate(i)
 	if len(n.children) > 0 {
 		next.children = append(next.children, n.children[i+1:]...)
 		n.children.truncate(i + 1)
 	}
+	next.recount()
+	n.count -= next.count + 1
 	return item, next
 }
 
 // maybeSplitChild checks if a child should be split, and if so splits it.
 // Returns whether or not a split occurred.
-func (n *node) maybeSplitChild(i int) bool {
-	if len(n.children[i].items) < MaxItems {
+func (n *node) maybeSplitChild(i, maxItems int) bool {
+	if len(n.children[i].items) < maxItems {
 		return false
 	}
 	first := n.mutableChild(i)
-	item, second := first.split(MaxItems / 2)
+	item, second := first.split(maxItems / 2)
 	n.items.insertAt(i, item)
 	n.children.insertAt(i+1, second)
 	return true
 }
 
+// find returns the index where item belongs in n.items, as items.find does.
+// If hint is non-nil, the search starts from the index the hint remembers
+// for this depth, and the hint is updated with the result.
+func (n *node) find(item *Item, hint *PathHint, depth int) (int, bool) {
+	if n.isPacked() {
+		rest, i, ok := n.trimPrefix(item[0])
+		if !ok {
+			if hint != nil && depth < len(hint.path) {
+				hint.used[depth] = true
+				hint.path[depth] = int32(i)
+			}
+			return i, false
+		}
+		item = &Item{rest}
+	}
+	if hint == nil || depth >= len(hint.path) {
+		return n.items.find(item, n.cow.compare)
+	}
+	var i int
+	var found bool
+	if hint.used[depth] {
+		i, found = n.items.findHint(item, n.cow.compare, int(hint.path[depth]))
+	} else {
+		i, found = n.items.find(item, n.cow.compare)
+	}
+	hint.used[depth] = true
+	hint.path[depth] = int32(i)
+	return i, found
+}
+
 // insert inserts an item into the subtree rooted at this node, making sure
 // no nodes in the subtree exceed maxItems items.  Should an equivalent item be
-// be found/replaced by insert, it will be returned.
-func (n *node) insert(item *Item) *Item {
-	i, found := n.items.find(item)
+// be found/replaced by insert, it will be returned along with true.
+func (n *node) insert(item Item, maxItems int, hint *PathHint, depth int) (Item, bool) {
+	i, found := n.find(&item, hint, depth)
 	if found {
 		out := n.items[i]
-		n.items[i] = item
-		return out
+		if n.isPacked() {
+			out = n.item(i)
+			n.items[i][1] = item[1]
+		} else {
+			n.items[i] = item
+		}
+		return out, true
 	}
 	if len(n.children) == 0 {
-		n.items.insertAt(i, item)
-		return nil
+		n.insertLeaf(i, item)
+		n.count++
+		return Item{}, false
 	}
-	if n.maybeSplitChild(i) {
-		inTree := n.items[i]
-		switch {
-		case item.Less(inTree):
+	if n.maybeSplitChild(i, maxItems) {
+		switch c := n.cow.compare(item[0], n.items[i][0]); {
+		case c < 0:
 			// no change, we want first split node
-		case inTree.Less(item):
+		case c > 0:
 			i++ // we want second split node
+			if hint != nil && depth < len(hint.path) {
+				hint.path[depth] = int32(i)
+			}
 		default:
 			out := n.items[i]
 			n.items[i] = item
-			return out
+			return out, true
 		}
 	}
-	return n.mutableChild(i).insert(item)
+	out, found := n.mutableChild(i).insert(item, maxItems, hint, depth+1)
+	if !found {
+		n.count++
+	}
+	return out, found
 }
 
-// get finds the given key in the subtree and returns it.
-func (n *node) get(key *Item) *Item {
-	i, found := n.items.find(key)
+// get finds the given key in the subtree and returns its value.
+func (n *node) get(key *Item, hint *PathHint, depth int) ([]byte, bool) {
+	i, found := n.find(key, hint, depth)
 	if found {
-		return n.items[i]
+		return n.items[i][1], true
 	}
 	if len(n.children) > 0 {
-		return n.children[i].get(key)
+		return n.children[i].get(key, hint, depth+1)
+	}
+	return nil, false
+}
+
+// getAt returns the item at index i, in ascending order, of the subtree.  i
+// must be less than n.count.
+func (n *node) getAt(i int) Item {
+	if len(n.children) == 0 {
+		return n.item(i)
+	}
+	for j := range n.items {
+		c := n.children[j].count
+		switch {
+		case i < c:
+			return n.children[j].getAt(i)
+		case i == c:
+			return n.item(j)
+		}
+		i -= c + 1
+	}
+	return n.children[len(n.items)].getAt(i)
+}
+
+// rank returns the number of items in the subtree that are less than key.
+func (n *node) rank(key *Item) int {
+	i, found := n.find(key, nil, 0)
+	r := i
+	if len(n.children) == 0 {
+		return r
+	}
+	for _, c := range n.children[:i] {
+		r += c.count
+	}
+	if found {
+		return r + n.children[i].count
 	}
-	return nil
+	return r + n.children[i].rank(key)
 }
 
-// min returns the first item in the subtree.
-func min(n *node) *Item {
+// min returns the first item in the subtree, or the zero Item if it is empty.
+func min(n *node) Item {
 	if n == nil {
-		return nil
+		return Item{}
 	}
 	for len(n.children) > 0 {
 		n = n.children[0]
 	}
 	if len(n.items) == 0 {
-		return nil
+		return Item{}
 	}
-	return n.items[0]
+	return n.item(0)
 }
 
-// max returns the last item in the subtree.
-func max(n *node) *Item {
+// max returns the last item in the subtree, or the zero Item if it is empty.
+func max(n *node) Item {
 	if n == nil {
-		return nil
+		return Item{}
 	}
 	for len(n.children) > 0 {
 		n = n.children[len(n.children)-1]
 	}
 	if len(n.items) == 0 {
-		return nil
+		return Item{}
 	}
-	return n.items[len(n.items)-1]
+	return n.item(len(n.items) - 1)
 }
 
 // toRemove details what item to remove in a node.remove call.
@@ -394,35 +644,39 @@ const (
 	removeMax                  // removes largest item in the subtree
 )
 
-// remove removes an item from the subtree rooted at this node.
-func (n *node) remove(item *Item, typ toRemove) *Item {
+// remove removes an item from the subtree rooted at this node.  It returns
+// the removed item and true, or false if there was nothing to remove.
+func (n *node) remove(item *Item, minItems int, typ toRemove, hint *PathHint, depth int) (Item, bool) {
 	var i int
 	var found bool
 	switch typ {
 	case removeMax:
 		if len(n.children) == 0 {
-			return n.items.pop()
+			n.count--
+			return n.removeLeaf(len(n.items) - 1), true
 		}
 		i = len(n.items)
 	case removeMin:
 		if len(n.children) == 0 {
-			return n.items.removeAt(0)
+			n.count--
+			return n.removeLeaf(0), true
 		}
 		i = 0
 	case removeItem:
-		i, found = n.items.find(item)
+		i, found = n.find(item, hint, depth)
 		if len(n.children) == 0 {
 			if found {
-				return n.items.removeAt(i)
+				n.count--
+				return n.removeLeaf(i), true
 			}
-			return nil
+			return Item{}, false
 		}
 	default:
 		panic("invalid type")
 	}
 	// If we get to here, we have children.
-	if len(n.children[i].items) <= MinItems {
-		return n.growChildAndRemove(i, item, typ)
+	if len(n.children[i].items) <= minItems {
+		return n.growChildAndRemove(i, item, minItems, typ, hint, depth)
 	}
 	child := n.mutableChild(i)
 	// Either we had enough items to begin with, or we've done some
@@ -435,12 +689,18 @@ func (n *node) remove(item *Item, typ toRemove) *Item {
 		// We use our special-case 'remove' call with typ=maxItem to pull the
 		// predecessor of item i (the rightmost leaf of our immediate left child)
 		// and set it into where we pulled the item from.
-		n.items[i] = child.remove(nil, removeMax)
-		return out
+		pred, _ := child.remove(nil, minItems, removeMax, nil, 0)
+		n.items[i] = n.cow.detach(pred)
+		n.count--
+		return out, true
 	}
 	// Final recursive call.  Once we're here, we know that the item isn't in this
 	// node and that the child is big enough to remove from.
-	return child.remove(item, typ)
+	out, removed := child.remove(item, minItems, typ, hint, depth+1)
+	if removed {
+		n.count--
+	}
+	return out, removed
 }
 
 // growChildAndRemove grows child 'i' to make sure it's possible to remove an
@@ -462,41 +722,57 @@ func (n *node) remove(item *Item, typ toRemove) *Item {
 // We then simply redo our remove call, and the second time (regardless of
 // whether we're in case 1 or 2), we'll have enough items and can guarantee
 // that we hit case A.
-func (n *node) growChildAndRemove(i int, item *Item, typ toRemove) *Item {
-	if i > 0 && len(n.children[i-1].items) > MinItems {
+func (n *node) growChildAndRemove(i int, item *Item, minItems int, typ toRemove, hint *PathHint, depth int) (Item, bool) {
+	if i > 0 && len(n.children[i-1].items) > minItems {
 		// Steal from left child
 		child := n.mutableChild(i)
 		stealFrom := n.mutableChild(i - 1)
+		child.open()
+		stealFrom.open()
 		stolenItem := stealFrom.items.pop()
 		child.items.insertAt(0, n.items[i-1])
-		n.items[i-1] = stolenItem
+		n.items[i-1] = n.cow.detach(stolenItem)
+		moved := 1
 		if len(stealFrom.children) > 0 {
-			child.children.insertAt(0, stealFrom.children.pop())
+			stolenChild := stealFrom.children.pop()
+			child.children.insertAt(0, stolenChild)
+			moved += stolenChild.count
 		}
-	} else if i < len(n.items) && len(n.children[i+1].items) > MinItems {
+		child.count += moved
+		stealFrom.count -= moved
+	} else if i < len(n.items) && len(n.children[i+1].items) > minItems {
 		// steal from right child
 		child := n.mutableChild(i)
 		stealFrom := n.mutableChild(i + 1)
+		child.open()
+		stealFrom.open()
 		stolenItem := stealFrom.items.removeAt(0)
 		child.items = append(child.items, n.items[i])
-		n.items[i] = stolenItem
+		n.items[i] = n.cow.detach(stolenItem)
+		moved := 1
 		if len(stealFrom.children) > 0 {
-			child.children = append(child.children, stealFrom.children.removeAt(0))
+			stolenChild := stealFrom.children.removeAt(0)
+			child.children = append(child.children, stolenChild)
+			moved += stolenChild.count
 		}
+		child.count += moved
+		stealFrom.count -= moved
 	} else {
 		if i >= len(n.items) {
 			i--
 		}
 		child := n.mutableChild(i)
+		child.open()
 		// merge with right child
 		mergeItem := n.items.removeAt(i)
 		mergeChild := n.children.removeAt(i + 1)
 		child.items = append(child.items, mergeItem)
-		child.items = append(child.items, mergeChild.items...)
+		child.items = append(child.items, mergeChild.fullItems()...)
 		child.children = append(child.children, mergeChild.children...)
+		child.count += 1 + mergeChild.count
 		n.cow.freeNode(mergeChild)
 	}
-	return n.remove(item, typ)
+	return n.remove(item, minItems, typ, hint, depth)
 }
 
 type direction int
@@ -516,26 +792,28 @@ const (
 func (n *node) iterate(dir direction, start, stop *Item, includeStart bool, hit bool, iter ItemIterator) (bool, bool) {
 	var ok, found bool
 	var index int
+	compare := n.cow.compare
+	s := n.fullItems()
 	switch dir {
 	case ascend:
 		if start != nil {
-			index, _ = n.items.find(start)
+			index, _ = s.find(start, compare)
 		}
-		for i := index; i < len(n.items); i++ {
+		for i := index; i < len(s); i++ {
 			if len(n.children) > 0 {
 				if hit, ok = n.children[i].iterate(dir, start, stop, includeStart, hit, iter); !ok {
 					return hit, false
 				}
 			}
-			if !includeStart && !hit && start != nil && !start.Less(n.items[i]) {
+			if !includeStart && !hit && start != nil && compare(start[0], s[i][0]) >= 0 {
 				hit = true
 				continue
 			}
 			hit = true
-			if stop != nil && !n.items[i].Less(stop) {
+			if stop != nil && compare(s[i][0], stop[0]) >= 0 {
 				return hit, false
 			}
-			if !iter(n.items[i][0], n.items[i][1]) {
+			if !iter(s[i][0], s[i][1]) {
 				return hit, false
 			}
 		}
@@ -546,16 +824,16 @@ func (n *node) iterate(dir direction, start, stop *Item, includeStart bool, hit
 		}
 	case descend:
 		if start != nil {
-			index, found = n.items.find(start)
+			index, found = s.find(start, compare)
 			if !found {
 				index--
 			}
 		} else {
-			index = len(n.items) - 1
+			index = len(s) - 1
 		}
 		for i := index; i >= 0; i-- {
-			if start != nil && !n.items[i].Less(start) {
-				if !includeStart || hit || start.Less(n.items[i]) {
+			if start != nil && compare(s[i][0], start[0]) >= 0 {
+				if !includeStart || hit || compare(start[0], s[i][0]) < 0 {
 					continue
 				}
 			}
@@ -564,11 +842,11 @@ func (n *node) iterate(dir direction, start, stop *Item, includeStart bool, hit
 					return hit, false
 				}
 			}
-			if stop != nil && !stop.Less(n.items[i]) {
+			if stop != nil && compare(stop[0], s[i][0]) >= 0 {
 				return hit, false //	continue
 			}
 			hit = true
-			if !iter(n.items[i][0], n.items[i][1]) {
+			if !iter(s[i][0], s[i][1]) {
 				return hit, false
 			}
 		}
@@ -595,11 +873,27 @@ func (n *node) print(w io.Writer, level int) {
 // removal, and iteration.
 //
 // Write operations are not safe for concurrent mutation by multiple
-// goroutines, but Read operations are.
+// goroutines, but Read operations are.  SyncBTree wraps a BTree for
+// concurrent reads and writes.
 type BTree struct {
+	degree int
+	length int
 	root   *node
 	cow    *copyOnWriteContext
-	length int
+	// commits records the writes of optimistic transactions committed to
+	// the tree, once one has begun.
+	commits *commitLog
+}
+
+// maxItems returns the max number of items to allow per node.
+func (t *BTree) maxItems() int {
+	return t.degree*2 - 1
+}
+
+// minItems returns the min number of items to allow per node (ignored for the
+// root node).
+func (t *BTree) minItems() int {
+	return t.degree - 1
 }
 
 // copyOnWriteContext pointers determine node ownership... a tree with a write
@@ -618,6 +912,14 @@ type BTree struct {
 // copy.
 type copyOnWriteContext struct {
 	freelist *FreeList
+	compare  CompareFunc
+	// customCompare is set if compare isn't the default bytes.Compare.
+	customCompare bool
+	copyItems     bool
+	arena         *arena
+	compress      bool
+	merge         MergeFunc
+	dirty         []*node // nodes to pack once the current write is done
 }
 
 // Clone clones the btree, lazily.  Clone should not be called concurrently,
@@ -638,15 +940,31 @@ func (t *BTree) Clone() (t2 *BTree) {
 	//   the new b.cow nodes
 	//   the new out.cow nodes
 	cow1, cow2 := *t.cow, *t.cow
+	if t.cow.arena != nil {
+		cow1.arena, cow2.arena = t.cow.arena.fork(), t.cow.arena.fork()
+	}
+	cow1.dirty, cow2.dirty = nil, nil
 	out := *t
 	t.cow = &cow1
 	out.cow = &cow2
+	out.commits = nil
 	return &out
 }
 
+// disown gives t a new write context, so that it copies every node it has
+// before writing to it, leaving them free to be shared.
+func (t *BTree) disown() {
+	cow := *t.cow
+	cow.dirty = nil
+	t.cow = &cow
+}
+
 func (c *copyOnWriteContext) newNode() (n *node) {
 	n = c.freelist.newNode()
 	n.cow = c
+	if c.compress {
+		c.dirty = append(c.dirty, n)
+	}
 	return
 }
 
@@ -663,9 +981,15 @@ const (
 // documentation).
 func (c *copyOnWriteContext) freeNode(n *node) freeType {
 	if n.cow == c {
+		if c.compress {
+			c.forgetDirty(n)
+		}
 		// clear to allow GC
 		n.items.truncate(0)
 		n.children.truncate(0)
+		n.count = 0
+		n.prefix = nil
+		n.loose = 0
 		n.cow = nil
 		if c.freelist.freeNode(n) {
 			return ftStored
@@ -675,14 +999,24 @@ func (c *copyOnWriteContext) freeNode(n *node) freeType {
 	return ftNotOwned
 }
 
-var itemPool = sync.Pool{
-	New: func() interface{} { return &Item{} },
-}
-
-func wrap(k, v []byte) *Item {
-	it := itemPool.Get().(*Item)
-	it[0], it[1] = k, v
-	return it
+// own returns the key and value to store in a tree for k and v: copies of
+// them, sharing a single allocation, if the tree copies items, or k and v
+// themselves otherwise.  A nil value stays nil.
+func (c *copyOnWriteContext) own(k, v []byte) ([]byte, []byte) {
+	if c.arena != nil {
+		return c.arena.own(k, v)
+	}
+	if !c.copyItems {
+		return k, v
+	}
+	buf := make([]byte, len(k)+len(v))
+	copy(buf, k)
+	ck := buf[:len(k):len(k)]
+	if v == nil {
+		return ck, nil
+	}
+	copy(buf[len(k):], v)
+	return ck, buf[len(k):]
 }
 
 // ReplaceOrInsert adds the given item to the tree.  If an item in the tree
@@ -691,33 +1025,47 @@ func wrap(k, v []byte) *Item {
 //
 // nil cannot be added to the tree (will panic).
 func (t *BTree) ReplaceOrInsert(k, v []byte) ([]byte, []byte) {
+	return t.replaceOrInsert(k, v, nil)
+}
+
+// ReplaceOrInsertHint is ReplaceOrInsert, guided by and updating hint.
+func (t *BTree) ReplaceOrInsertHint(k, v []byte, hint *PathHint) ([]byte, []byte) {
+	return t.replaceOrInsert(k, v, hint)
+}
+
+func (t *BTree) replaceOrInsert(k, v []byte, hint *PathHint) ([]byte, []byte) {
 	if k == nil {
 		panic("nil item being added to BTree")
 	}
+	defer t.cow.packDirty()
 
-	in := wrap(k, v)
+	k, v = t.cow.own(k, v)
+	in := Item{k, v}
 	if t.root == nil {
 		t.root = t.cow.newNode()
 		t.root.items = append(t.root.items, in)
+		t.root.count = 1
 		t.length++
 		return nil, nil
 	}
 
 	t.root = t.root.mutableFor(t.cow)
-	if len(t.root.items) >= MaxItems {
-		item2, second := t.root.split(MaxItems / 2)
+	if len(t.root.items) >= t.maxItems() {
+		item2, second := t.root.split(t.maxItems() / 2)
 		oldroot := t.root
 		t.root = t.cow.newNode()
 		t.root.items = append(t.root.items, item2)
 		t.root.children = append(t.root.children, oldroot, second)
+		t.root.count = oldroot.count + 1 + second.count
 	}
-	out := t.root.insert(in)
-	if out == nil {
+	out, found := t.root.insert(in, t.maxItems(), hint, 0)
+	if !found {
 		t.length++
 		return nil, nil
 	}
-	if in != out {
-		itemPool.Put(out)
+	if t.cow.arena != nil {
+		t.cow.arena.free(out)
+		t.maybeCompact()
 	}
 	return out[0], out[1]
 }
@@ -725,51 +1073,96 @@ func (t *BTree) ReplaceOrInsert(k, v []byte) ([]byte, []byte) {
 // Delete removes an item equal to the passed in item from the tree, returning
 // it.  If no such item exists, returns nil.
 func (t *BTree) Delete(k []byte) ([]byte, []byte) {
-	out := t.deleteItem(wrap(k, nil), removeItem)
-	if out == nil {
-		return nil, nil
-	}
-	itemPool.Put(out)
+	return t.delete(k, nil)
+}
+
+// DeleteHint is Delete, guided by and updating hint.
+func (t *BTree) DeleteHint(k []byte, hint *PathHint) ([]byte, []byte) {
+	return t.delete(k, hint)
+}
+
+func (t *BTree) delete(k []byte, hint *PathHint) ([]byte, []byte) {
+	seek := Item{k}
+	out, _ := t.deleteItem(&seek, removeItem, hint)
 	return out[0], out[1]
 }
 
 // DeleteMin removes the smallest item in the tree and returns it.
 // If no such item exists, returns nil.
 func (t *BTree) DeleteMin() ([]byte, []byte) {
-	it := t.deleteItem(nil, removeMin)
-	if it == nil {
-		return nil, nil
-	}
-	itemPool.Put(it)
+	it, _ := t.deleteItem(nil, removeMin, nil)
 	return it[0], it[1]
 }
 
 // DeleteMax removes the largest item in the tree and returns it.
 // If no such item exists, returns nil.
 func (t *BTree) DeleteMax() ([]byte, []byte) {
-	it := t.deleteItem(nil, removeMax)
-	if it == nil {
-		return nil, nil
-	}
-	itemPool.Put(it)
+	it, _ := t.deleteItem(nil, removeMax, nil)
 	return it[0], it[1]
 }
 
-func (t *BTree) deleteItem(item *Item, typ toRemove) *Item {
+// DeleteRange removes every item within the range [greaterOrEqual, lessThan)
+// and returns the number of items removed.
+//
+// Rather than removing items one by one, the tree is cut at both ends of the
+// range: subtrees that fall entirely within it are detached whole, with the
+// nodes this tree owns returned to its freelist, and only the nodes along the
+// two cut paths are rebalanced.
+func (t *BTree) DeleteRange(greaterOrEqual, lessThan []byte) int {
+	from, to := Item{greaterOrEqual}, Item{lessThan}
+	return t.deleteRange(&from, &to)
+}
+
+// deleteRange removes the items within the range [from, to), or every item
+// from 'from' onwards if to is nil.
+func (t *BTree) deleteRange(from, to *Item) int {
+	if t.root == nil {
+		return 0
+	}
+	end := t.length
+	if to != nil {
+		end = t.root.rank(to)
+	}
+	removed := end - t.root.rank(from)
+	if removed <= 0 {
+		return 0
+	}
+	defer t.cow.packDirty()
+	s := t.splicer()
+	left, hl, mid, hm := s.split(t.root, height(t.root), from)
+	var right *node
+	var hr int
+	if to != nil {
+		mid, _, right, hr = s.split(mid, hm, to)
+	}
+	t.root, _ = s.concat(left, hl, right, hr)
+	t.length -= removed
+	t.cow.release(mid)
+	mid.reset(t.cow)
+	t.maybeCompact()
+	return removed
+}
+
+func (t *BTree) deleteItem(item *Item, typ toRemove, hint *PathHint) (Item, bool) {
 	if t.root == nil || len(t.root.items) == 0 {
-		return nil
+		return Item{}, false
 	}
+	defer t.cow.packDirty()
 	t.root = t.root.mutableFor(t.cow)
-	out := t.root.remove(item, typ)
+	out, removed := t.root.remove(item, t.minItems(), typ, hint, 0)
 	if len(t.root.items) == 0 && len(t.root.children) > 0 {
 		oldroot := t.root
 		t.root = t.root.children[0]
 		t.cow.freeNode(oldroot)
 	}
-	if out != nil {
+	if removed {
 		t.length--
+		if t.cow.arena != nil {
+			t.cow.arena.free(out)
+			t.maybeCompact()
+		}
 	}
-	return out
+	return out, removed
 }
 
 // AscendRange calls the iterator for every value in the tree within the range
@@ -778,7 +1171,8 @@ func (t *BTree) AscendRange(greaterOrEqual, lessThan []byte, iterator ItemIterat
 	if t.root == nil {
 		return
 	}
-	t.root.iterate(ascend, wrap(greaterOrEqual, nil), wrap(lessThan, nil), true, false, iterator)
+	from, to := Item{greaterOrEqual}, Item{lessThan}
+	t.root.iterate(ascend, &from, &to, true, false, iterator)
 }
 
 // AscendLessThan calls the iterator for every value in the tree within the range
@@ -787,7 +1181,8 @@ func (t *BTree) AscendLessThan(pivot []byte, iterator ItemIterator) {
 	if t.root == nil {
 		return
 	}
-	t.root.iterate(ascend, nil, wrap(pivot, nil), false, false, iterator)
+	to := Item{pivot}
+	t.root.iterate(ascend, nil, &to, false, false, iterator)
 }
 
 // AscendGreaterOrEqual calls the iterator for every value in the tree within
@@ -796,7 +1191,8 @@ func (t *BTree) AscendGreaterOrEqual(pivot []byte, iterator ItemIterator) {
 	if t.root == nil {
 		return
 	}
-	t.root.iterate(ascend, wrap(pivot, nil), nil, true, false, iterator)
+	from := Item{pivot}
+	t.root.iterate(ascend, &from, nil, true, false, iterator)
 }
 
 // Ascend calls the iterator for every value in the tree within the range
@@ -814,7 +1210,8 @@ func (t *BTree) DescendRange(lessOrEqual, greaterThan []byte, iterator ItemItera
 	if t.root == nil {
 		return
 	}
-	t.root.iterate(descend, wrap(lessOrEqual, nil), wrap(greaterThan, nil), true, false, iterator)
+	from, to := Item{lessOrEqual}, Item{greaterThan}
+	t.root.iterate(descend, &from, &to, true, false, iterator)
 }
 
 // DescendLessOrEqual calls the iterator for every value in the tree within the range
@@ -823,7 +1220,8 @@ func (t *BTree) DescendLessOrEqual(pivot []byte, iterator ItemIterator) {
 	if t.root == nil {
 		return
 	}
-	t.root.iterate(descend, wrap(pivot, nil), nil, true, false, iterator)
+	from := Item{pivot}
+	t.root.iterate(descend, &from, nil, true, false, iterator)
 }
 
 // DescendGreaterThan calls the iterator for every value in the tree within
@@ -832,7 +1230,8 @@ func (t *BTree) DescendGreaterThan(pivot []byte, iterator ItemIterator) {
 	if t.root == nil {
 		return
 	}
-	t.root.iterate(descend, nil, wrap(pivot, nil), false, false, iterator)
+	to := Item{pivot}
+	t.root.iterate(descend, nil, &to, false, false, iterator)
 }
 
 // Descend calls the iterator for every value in the tree within the range
@@ -845,38 +1244,97 @@ func (t *BTree) Descend(iterator ItemIterator) {
 }
 
 // Get looks for the key item in the tree, returning it.  It returns nil if
-// unable to find that item.
+// unable to find that item.  The returned value belongs to the tree and must
+// not be modified; see GetCopy and AppendValue.
 func (t *BTree) Get(key []byte) ([]byte, bool) {
+	return t.get(key, nil)
+}
+
+// GetHint is Get, guided by and updating hint.
+func (t *BTree) GetHint(key []byte, hint *PathHint) ([]byte, bool) {
+	return t.get(key, hint)
+}
+
+func (t *BTree) get(key []byte, hint *PathHint) ([]byte, bool) {
 	if t.root == nil {
 		return nil, false
 	}
-	seek := wrap(key, nil)
-	it := t.root.get(seek)
-	itemPool.Put(seek)
-	if it == nil {
-		return nil, false
-	}
-	return it[1], true
+	seek := Item{key}
+	return t.root.get(&seek, hint, 0)
 }
 
 // Min returns the smallest item in the tree, or nil if the tree is empty.
 func (t *BTree) Min() ([]byte, []byte) {
 	it := min(t.root)
-	if it == nil {
-		return nil, nil
-	}
 	return it[0], it[1]
 }
 
 // Max returns the largest item in the tree, or nil if the tree is empty.
 func (t *BTree) Max() ([]byte, []byte) {
 	it := max(t.root)
-	if it == nil {
+	return it[0], it[1]
+}
+
+// GetAt returns the item at index i of the tree in ascending order.  It
+// returns nils if i is out of range.
+func (t *BTree) GetAt(i int) ([]byte, []byte) {
+	if t.root == nil || i < 0 || i >= t.root.count {
 		return nil, nil
 	}
+	it := t.root.getAt(i)
 	return it[0], it[1]
 }
 
+// DeleteAt removes the item at index i of the tree in ascending order,
+// returning it.  It returns nils if i is out of range.
+func (t *BTree) DeleteAt(i int) ([]byte, []byte) {
+	if t.root == nil || i < 0 || i >= t.root.count {
+		return nil, nil
+	}
+	it := t.root.getAt(i)
+	out, _ := t.deleteItem(&it, removeItem, nil)
+	return out[0], out[1]
+}
+
+// Rank returns the number of items in the tree whose key is less than key.
+// When key is in the tree, this is its index in ascending order.
+func (t *BTree) Rank(key []byte) int {
+	if t.root == nil {
+		return 0
+	}
+	seek := Item{key}
+	return t.root.rank(&seek)
+}
+
+// CountRange returns the number of items in the tree within the range
+// [greaterOrEqual, lessThan).
+func (t *BTree) CountRange(greaterOrEqual, lessThan []byte) int {
+	if n := t.Rank(lessThan) - t.Rank(greaterOrEqual); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// GetCopy is Get, but returns a copy of the value that the caller is free to
+// modify.
+func (t *BTree) GetCopy(key []byte) ([]byte, bool) {
+	return t.AppendValue(nil, key)
+}
+
+// AppendValue appends the value of key to dst and returns the extended
+// buffer, along with whether key was found.  dst is returned unchanged if it
+// wasn't.
+func (t *BTree) AppendValue(dst, key []byte) ([]byte, bool) {
+	v, ok := t.get(key, nil)
+	if !ok {
+		return dst, false
+	}
+	if dst == nil && v != nil {
+		dst = make([]byte, 0, len(v))
+	}
+	return append(dst, v...), true
+}
+
 // Has returns true if the given key is in the tree.
 func (t *BTree) Has(key []byte) bool {
 	_, ok := t.Get(key)
@@ -913,6 +1371,9 @@ func (t *BTree) Clear(addNodesToFreelist bool) {
 		t.root.reset(t.cow)
 	}
 	t.root, t.length = nil, 0
+	if a := t.cow.loadArena(); a != nil {
+		t.cow.arena = a
+	}
 }
 
 // reset returns a subtree to the freelist.  It breaks out immediately if the
@@ -14,63 +14,61 @@
 
 // +build ignore
 
-// This binary compares memory usage between btree and gollrb.
+// This binary measures the memory used by a bytebtree holding keys made of a
+// bucket prefix followed by a hash, with and without key compression.
 package main
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"flag"
 	"fmt"
-	"math/rand"
 	"runtime"
 	"time"
 
-	"github.com/google/btree"
-	"github.com/petar/GoLLRB/llrb"
+	"github.com/AskAlexSharov/bytebtree"
 )
 
 var (
-	size   = flag.Int("size", 1000000, "size of the tree to build")
-	degree = flag.Int("degree", 8, "degree of btree")
-	gollrb = flag.Bool("llrb", false, "use llrb instead of btree")
+	size     = flag.Int("size", 1000000, "size of the tree to build")
+	degree   = flag.Int("degree", bytebtree.Degree, "degree of btree")
+	buckets  = flag.Int("buckets", 16, "number of distinct 8-byte key prefixes")
+	hashLen  = flag.Int("hash", 32, "length of the hash following the prefix")
+	compress = flag.Bool("compress", false, "use Options.CompressKeys")
 )
 
 func main() {
 	flag.Parse()
-	vals := rand.Perm(*size)
-	var t, v interface{}
-	v = vals
+	keys := make([][]byte, *size)
+	for i := range keys {
+		k := make([]byte, 8+*hashLen)
+		binary.BigEndian.PutUint64(k, uint64(i%*buckets))
+		rand.Read(k[8:])
+		keys[i] = k
+	}
+	value := []byte("v")
 	var stats runtime.MemStats
 	for i := 0; i < 10; i++ {
 		runtime.GC()
 	}
-	fmt.Println("-------- BEFORE ----------")
 	runtime.ReadMemStats(&stats)
-	fmt.Printf("%+v\n", stats)
+	before := stats.HeapAlloc
+	fmt.Printf("keys: %d MiB\n", before>>20)
+
 	start := time.Now()
-	if *gollrb {
-		tr := llrb.New()
-		for _, v := range vals {
-			tr.ReplaceOrInsert(llrb.Int(v))
-		}
-		t = tr // keep it around
-	} else {
-		tr := btree.New(*degree)
-		for _, v := range vals {
-			tr.ReplaceOrInsert(btree.Int(v))
-		}
-		t = tr // keep it around
+	tr := bytebtree.NewWithOptions(bytebtree.Options{Degree: *degree, CompressKeys: *compress})
+	for _, k := range keys {
+		tr.ReplaceOrInsert(k, value)
 	}
 	fmt.Printf("%v inserts in %v\n", *size, time.Since(start))
-	fmt.Println("-------- AFTER ----------")
-	runtime.ReadMemStats(&stats)
-	fmt.Printf("%+v\n", stats)
+	// Drop the caller's keys: with CompressKeys only the tree's copies stay.
+	keys = nil
+
 	for i := 0; i < 10; i++ {
 		runtime.GC()
 	}
-	fmt.Println("-------- AFTER GC ----------")
 	runtime.ReadMemStats(&stats)
-	fmt.Printf("%+v\n", stats)
-	if t == v {
-		fmt.Println("to make sure vals and tree aren't GC'd")
-	}
+	fmt.Printf("heap: %d MiB, %.1f bytes per item, %d objects\n",
+		stats.HeapAlloc>>20, float64(stats.HeapAlloc)/float64(tr.Len()), stats.HeapObjects)
+	runtime.KeepAlive(tr)
 }
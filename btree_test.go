@@ -143,10 +143,10 @@ func TestBTree(t *testing.T) {
 			t.Fatalf("mismatch:\n got: %x\nwant: %x", gotValues, wantValues)
 		}
 
-		gotrev, gotrevValues := all(tr)
+		gotrev, gotrevValues := allrev(tr)
 		wantrev, wantrevValues := revorder(keys, values)
 		if !reflect.DeepEqual(gotrev, wantrev) {
-			t.Fatalf("mismatch:\n got: %x\nwant: %x", got, want)
+			t.Fatalf("mismatch:\n got: %x\nwant: %x", gotrev, wantrev)
 		}
 		if !reflect.DeepEqual(gotrevValues, wantrevValues) {
 			t.Fatalf("mismatch:\n got: %x\nwant: %x", gotrevValues, wantrevValues)
@@ -163,6 +163,417 @@ func TestBTree(t *testing.T) {
 	}
 }
 
+// checkTree verifies the structural invariants of tr: node sizes stay within
+// the bounds for its degree, all leaves sit at the same depth, subtree counts
+// are accurate and keys are strictly ascending.
+func checkTree(t *testing.T, tr *BTree) {
+	t.Helper()
+	if tr.root == nil {
+		if tr.length != 0 {
+			t.Fatalf("nil root with length %d", tr.length)
+		}
+		return
+	}
+	leafDepth := -1
+	var prev []byte
+	total := 0
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		before := total
+		if n != tr.root && (len(n.items) < tr.minItems() || len(n.items) > tr.maxItems()) {
+			t.Fatalf("node at depth %d has %d items, want [%d, %d]", depth, len(n.items), tr.minItems(), tr.maxItems())
+		}
+		if len(n.children) == 0 {
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if leafDepth != depth {
+				t.Fatalf("leaf at depth %d, want %d", depth, leafDepth)
+			}
+		} else if len(n.children) != len(n.items)+1 {
+			t.Fatalf("node has %d items and %d children", len(n.items), len(n.children))
+		}
+		for i, it := range n.fullItems() {
+			if len(n.children) > 0 {
+				walk(n.children[i], depth+1)
+			}
+			if prev != nil && tr.cow.compare(prev, it[0]) >= 0 {
+				t.Fatalf("keys out of order: %x >= %x", prev, it[0])
+			}
+			prev = it[0]
+			total++
+		}
+		if len(n.children) > 0 {
+			walk(n.children[len(n.children)-1], depth+1)
+		}
+		if n.count != total-before {
+			t.Fatalf("node at depth %d has count %d, holds %d items", depth, n.count, total-before)
+		}
+	}
+	walk(tr.root, 0)
+	if total != tr.length {
+		t.Fatalf("tree holds %d items, length is %d", total, tr.length)
+	}
+}
+
+func TestBTreeDegree(t *testing.T) {
+	for _, degree := range []int{2, 3, 4, 8, 32} {
+		t.Run(fmt.Sprintf("degree=%d", degree), func(t *testing.T) {
+			tr := NewWithOptions(Options{Degree: degree})
+			keys, values := perm(2000)
+			for i := range keys {
+				if x, _ := tr.ReplaceOrInsert(keys[i], values[i]); x != nil {
+					t.Fatalf("insert found item: %x", keys[i])
+				}
+			}
+			checkTree(t, tr)
+			got, gotValues := all(tr)
+			want, wantValues := rang0(keys, values)
+			if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(gotValues, wantValues) {
+				t.Fatalf("mismatch after insert")
+			}
+			for i := 0; i < len(keys)/2; i++ {
+				if x, _ := tr.Delete(keys[i]); x == nil {
+					t.Fatalf("didn't find %x", keys[i])
+				}
+			}
+			checkTree(t, tr)
+			for i := len(keys) / 2; i < len(keys); i++ {
+				if v, ok := tr.Get(keys[i]); !ok || !bytes.Equal(v, values[i]) {
+					t.Fatalf("get %x: got %x, %v", keys[i], v, ok)
+				}
+			}
+			for k, _ := tr.DeleteMax(); k != nil; k, _ = tr.DeleteMax() {
+			}
+			checkTree(t, tr)
+			if tr.Len() != 0 {
+				t.Fatalf("len after draining: %d", tr.Len())
+			}
+		})
+	}
+}
+
+func TestNewWithOptionsBadDegree(t *testing.T) {
+	for _, degree := range []int{-1, 1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("degree %d: expected panic", degree)
+				}
+			}()
+			NewWithOptions(Options{Degree: degree})
+		}()
+	}
+	if tr := NewWithOptions(Options{}); tr.degree != Degree {
+		t.Fatalf("zero degree: got %d, want %d", tr.degree, Degree)
+	}
+}
+
+func reverseCompare(a, b []byte) int { return bytes.Compare(b, a) }
+
+func TestCompareReverse(t *testing.T) {
+	tr := NewWithOptions(Options{Degree: 3, Compare: reverseCompare})
+	keys, values := perm(500)
+	for i := range keys {
+		tr.ReplaceOrInsert(keys[i], values[i])
+	}
+	checkTree(t, tr)
+	got, _ := all(tr)
+	want, _ := rang0(keys, values)
+	for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+		want[i], want[j] = want[j], want[i]
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ascend under reverse order:\n got: %x\nwant: %x", got, want)
+	}
+	var ranged [][]byte
+	tr.AscendRange(want[100], want[200], func(k, v []byte) bool {
+		ranged = append(ranged, k)
+		return true
+	})
+	if !reflect.DeepEqual(ranged, want[100:200]) {
+		t.Fatalf("ascendrange under reverse order:\n got: %x\nwant: %x", ranged, want[100:200])
+	}
+
+	// A clone must keep ordering keys the same way as its source.
+	clone := tr.Clone()
+	for i := 0; i < len(keys)/2; i++ {
+		clone.Delete(keys[i])
+	}
+	checkTree(t, clone)
+	checkTree(t, tr)
+	if min, _ := clone.Min(); clone.cow.compare(min, want[0]) < 0 {
+		t.Fatalf("clone min %x sorts before %x", min, want[0])
+	}
+}
+
+// bigIntCompare orders variable-length big-endian unsigned integers.
+func bigIntCompare(a, b []byte) int {
+	a, b = bytes.TrimLeft(a, "\x00"), bytes.TrimLeft(b, "\x00")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(a, b)
+}
+
+func TestCompareCustom(t *testing.T) {
+	tr := NewWithOptions(Options{Degree: 2, Compare: bigIntCompare})
+	for _, k := range []string{"\x01\x00", "\x02", "\x00\x03", "\xff", "\x01"} {
+		tr.ReplaceOrInsert([]byte(k), nil)
+	}
+	// "\x00\x03" and "\x03" are the same number.
+	if _, ok := tr.Get([]byte("\x03")); !ok {
+		t.Fatalf("expected \\x03 to be found as \\x00\\x03")
+	}
+	got, _ := all(tr)
+	want := [][]byte{{0x01}, {0x02}, {0x00, 0x03}, {0xff}, {0x01, 0x00}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %x, want %x", got, want)
+	}
+
+	fold := NewWithOptions(Options{Compare: func(a, b []byte) int {
+		return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+	}})
+	fold.ReplaceOrInsert([]byte("Key"), []byte("1"))
+	if old, _ := fold.ReplaceOrInsert([]byte("KEY"), []byte("2")); string(old) != "Key" {
+		t.Fatalf("case-insensitive replace returned %q", old)
+	}
+	if fold.Len() != 1 {
+		t.Fatalf("len: got %d, want 1", fold.Len())
+	}
+}
+
+func TestOrderStatistics(t *testing.T) {
+	for _, degree := range []int{2, 3, 8} {
+		tr := NewWithOptions(Options{Degree: degree})
+		keys, values := perm(1000)
+		for i := range keys {
+			tr.ReplaceOrInsert(keys[i], values[i])
+		}
+		checkTree(t, tr)
+		want, wantValues := rang0(keys, values)
+		for i := range want {
+			k, v := tr.GetAt(i)
+			if !bytes.Equal(k, want[i]) || !bytes.Equal(v, wantValues[i]) {
+				t.Fatalf("degree %d: getat %d: got %x, want %x", degree, i, k, want[i])
+			}
+			if r := tr.Rank(want[i]); r != i {
+				t.Fatalf("degree %d: rank %x: got %d, want %d", degree, want[i], r, i)
+			}
+		}
+		if k, _ := tr.GetAt(len(want)); k != nil {
+			t.Fatalf("degree %d: getat past the end returned %x", degree, k)
+		}
+		if k, _ := tr.GetAt(-1); k != nil {
+			t.Fatalf("degree %d: getat -1 returned %x", degree, k)
+		}
+		if n := tr.CountRange(want[100], want[350]); n != 250 {
+			t.Fatalf("degree %d: countrange: got %d, want 250", degree, n)
+		}
+		if n := tr.CountRange(want[350], want[100]); n != 0 {
+			t.Fatalf("degree %d: inverted countrange: got %d, want 0", degree, n)
+		}
+
+		// Counts must survive deletes on a clone without leaking into the
+		// original tree.
+		clone := tr.Clone()
+		for i := len(want) - 1; i >= 0; i -= 3 {
+			if k, _ := clone.DeleteAt(i); !bytes.Equal(k, want[i]) {
+				t.Fatalf("degree %d: deleteat %d: got %x, want %x", degree, i, k, want[i])
+			}
+		}
+		checkTree(t, clone)
+		checkTree(t, tr)
+		for i := 0; i < len(keys); i += 2 {
+			clone.Delete(keys[i])
+		}
+		checkTree(t, clone)
+		for clone.Len() > 0 {
+			clone.DeleteMin()
+		}
+		checkTree(t, clone)
+		if tr.Len() != len(want) {
+			t.Fatalf("degree %d: original len changed to %d", degree, tr.Len())
+		}
+	}
+}
+
+// seqKey returns the big-endian encoding of i, so that keys sort by i.
+func seqKey(i int) []byte {
+	return []byte{byte(i >> 24), byte(i >> 16), byte(i >> 8), byte(i)}
+}
+
+func TestPathHint(t *testing.T) {
+	for _, degree := range []int{2, 4, 32} {
+		tr := NewWithOptions(Options{Degree: degree})
+		var hint PathHint
+		const n = 5000
+		for i := 0; i < n; i++ {
+			if old, _ := tr.ReplaceOrInsertHint(seqKey(i), seqKey(i), &hint); old != nil {
+				t.Fatalf("degree %d: insert %d found %x", degree, i, old)
+			}
+		}
+		checkTree(t, tr)
+		for i := 0; i < n; i++ {
+			if v, ok := tr.GetHint(seqKey(i), &hint); !ok || !bytes.Equal(v, seqKey(i)) {
+				t.Fatalf("degree %d: gethint %d: got %x, %v", degree, i, v, ok)
+			}
+		}
+		// Random access keeps the hint wrong most of the time.
+		for _, i := range rand.Perm(n) {
+			if old, _ := tr.ReplaceOrInsertHint(seqKey(i), nil, &hint); !bytes.Equal(old, seqKey(i)) {
+				t.Fatalf("degree %d: replace %d returned %x", degree, i, old)
+			}
+		}
+		if _, ok := tr.GetHint(seqKey(n), &hint); ok {
+			t.Fatalf("degree %d: found a missing key", degree)
+		}
+		for i := 0; i < n; i += 2 {
+			if old, _ := tr.DeleteHint(seqKey(i), &hint); !bytes.Equal(old, seqKey(i)) {
+				t.Fatalf("degree %d: deletehint %d returned %x", degree, i, old)
+			}
+		}
+		checkTree(t, tr)
+		for i := 0; i < n; i++ {
+			_, ok := tr.GetHint(seqKey(i), &hint)
+			if want := i%2 == 1; ok != want {
+				t.Fatalf("degree %d: gethint %d after deletes: got %v, want %v", degree, i, ok, want)
+			}
+		}
+	}
+}
+
+func TestFindHint(t *testing.T) {
+	var s items
+	for _, k := range []string{"b", "d", "f", "h"} {
+		s = append(s, Item{[]byte(k)})
+	}
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"} {
+		wantIndex, wantFound := s.find(&Item{[]byte(k)}, bytes.Compare)
+		for guess := 0; guess <= len(s)+1; guess++ {
+			index, found := s.findHint(&Item{[]byte(k)}, bytes.Compare, guess)
+			if index != wantIndex || found != wantFound {
+				t.Errorf("findHint(%q, %d) = %d, %v; want %d, %v", k, guess, index, found, wantIndex, wantFound)
+			}
+		}
+	}
+}
+
+func TestDeleteRange(t *testing.T) {
+	for _, degree := range []int{2, 3, 4, 16} {
+		for iter := 0; iter < 50; iter++ {
+			size := rand.Intn(600)
+			tr := NewWithOptions(Options{Degree: degree})
+			for i := 0; i < size; i++ {
+				tr.ReplaceOrInsert(seqKey(i*2), seqKey(i))
+			}
+			snapshot := tr.Clone()
+			from, to := rand.Intn(size*2+2), rand.Intn(size*2+2)
+			if from > to {
+				from, to = to, from
+			}
+			want := 0
+			for i := 0; i < size; i++ {
+				if i*2 >= from && i*2 < to {
+					want++
+				}
+			}
+			if n := tr.DeleteRange(seqKey(from), seqKey(to)); n != want {
+				t.Fatalf("degree %d: deleterange [%d, %d) of %d: removed %d, want %d", degree, from, to, size, n, want)
+			}
+			checkTree(t, tr)
+			checkTree(t, snapshot)
+			for i := 0; i < size; i++ {
+				_, ok := tr.Get(seqKey(i * 2))
+				if inRange := i*2 >= from && i*2 < to; ok == inRange {
+					t.Fatalf("degree %d: deleterange [%d, %d): key %d present: %v", degree, from, to, i*2, ok)
+				}
+			}
+			if snapshot.Len() != size {
+				t.Fatalf("degree %d: clone lost items: %d, want %d", degree, snapshot.Len(), size)
+			}
+			// The tree must stay usable after being cut and rejoined.
+			for i := 0; i < size; i++ {
+				tr.ReplaceOrInsert(seqKey(i*2+1), nil)
+			}
+			checkTree(t, tr)
+		}
+	}
+}
+
+func TestCopyItems(t *testing.T) {
+	tr := NewWithOptions(Options{Degree: 2, CopyItems: true})
+	buf := make([]byte, 8)
+	for i := 0; i < 100; i++ {
+		copy(buf, seqKey(i))
+		copy(buf[4:], seqKey(-i))
+		tr.ReplaceOrInsert(buf[:4], buf[4:])
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := tr.Get(seqKey(i)); !ok || !bytes.Equal(v, seqKey(-i)) {
+			t.Fatalf("key %d after buffer reuse: got %x, %v", i, v, ok)
+		}
+	}
+
+	tr.ReplaceOrInsert([]byte("nil"), nil)
+	if v, ok := tr.Get([]byte("nil")); !ok || v != nil {
+		t.Fatalf("nil value: got %q, %v", v, ok)
+	}
+	key := []byte("k")
+	var i byte
+	loaded := tr.Clone()
+	err := loaded.BulkLoad(func() ([]byte, []byte, bool) {
+		if i == 50 {
+			return nil, nil, false
+		}
+		key[0] = i
+		i++
+		return key, key, true
+	})
+	if err != nil {
+		t.Fatalf("bulk load with a reused buffer: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if v, ok := loaded.Get([]byte{byte(i)}); !ok || !bytes.Equal(v, []byte{byte(i)}) {
+			t.Fatalf("bulk loaded key %d: got %x, %v", i, v, ok)
+		}
+	}
+}
+
+func TestGetCopy(t *testing.T) {
+	tr := New()
+	tr.ReplaceOrInsert([]byte("a"), []byte("value"))
+	v, ok := tr.GetCopy([]byte("a"))
+	if !ok || string(v) != "value" {
+		t.Fatalf("GetCopy: got %q, %v", v, ok)
+	}
+	v[0] = 'X'
+	if v, _ := tr.Get([]byte("a")); string(v) != "value" {
+		t.Fatalf("modifying a copy changed the tree: %q", v)
+	}
+	dst, ok := tr.AppendValue([]byte("prefix:"), []byte("a"))
+	if !ok || string(dst) != "prefix:value" {
+		t.Fatalf("AppendValue: got %q, %v", dst, ok)
+	}
+	dst[len("prefix:")] = 'X'
+	if v, _ := tr.Get([]byte("a")); string(v) != "value" {
+		t.Fatalf("modifying an appended value changed the tree: %q", v)
+	}
+	dst, ok = tr.AppendValue([]byte("prefix:"), []byte("b"))
+	if ok || string(dst) != "prefix:" {
+		t.Fatalf("AppendValue of a missing key: got %q, %v", dst, ok)
+	}
+}
+
+// rang0 returns sorted copies of keys and values, leaving the inputs intact.
+func rang0(keys, values [][]byte) ([][]byte, [][]byte) {
+	k := append([][]byte(nil), keys...)
+	v := append([][]byte(nil), values...)
+	return order(k, v)
+}
+
 /*
 func ExampleBTree() {
 	tr := New(*btreeDegree)
@@ -219,6 +630,36 @@ func TestDeleteMin(t *testing.T) {
 	}
 }
 
+// Items removed from a tree may still be in nodes it shares with a clone, so
+// they must not be reused for later writes.
+func TestCloneKeepsDeletedItems(t *testing.T) {
+	tr := New()
+	keys, values := perm(1000)
+	for i := range keys {
+		tr.ReplaceOrInsert(keys[i], values[i])
+	}
+	clone := tr.Clone()
+	for i := 0; i < len(keys)/2; i++ {
+		tr.Delete(keys[i])
+	}
+	tr.DeleteMin()
+	tr.DeleteMax()
+	more, moreValues := perm(1000)
+	for i := range more {
+		tr.ReplaceOrInsert(more[i], moreValues[i])
+		tr.ReplaceOrInsert(keys[len(keys)-1-i%10], moreValues[i])
+	}
+	checkTree(t, clone)
+	got, gotValues := all(clone)
+	want, wantValues := order(keys, values)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("clone keys changed:\n got: %x\nwant: %x", got, want)
+	}
+	if !reflect.DeepEqual(gotValues, wantValues) {
+		t.Fatalf("clone values changed:\n got: %x\nwant: %x", gotValues, wantValues)
+	}
+}
+
 /*
 
 func TestDeleteMax(t *testing.T) {
@@ -415,6 +856,79 @@ func BenchmarkInsert(b *testing.B) {
 	fmt.Printf("btree mem: %d\n", m.Alloc/1024/1024/1024)
 }
 
+func BenchmarkGet(b *testing.B) {
+	b.StopTimer()
+	keys, values := perm(benchmarkTreeSize)
+	tr := New()
+	for j := range keys {
+		tr.ReplaceOrInsert(keys[j], values[j])
+	}
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		tr.Get(keys[i%benchmarkTreeSize])
+	}
+}
+
+func BenchmarkInsertDegree(b *testing.B) {
+	const size = 100_000
+	keys, values := perm(size)
+	for _, degree := range []int{2, 8, 32, 128, 512} {
+		b.Run(fmt.Sprintf("degree=%d", degree), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				tr := NewWithOptions(Options{Degree: degree})
+				for j := range keys {
+					tr.ReplaceOrInsert(keys[j], values[j])
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetDegree(b *testing.B) {
+	const size = 100_000
+	keys, values := perm(size)
+	for _, degree := range []int{2, 8, 32, 128, 512} {
+		b.Run(fmt.Sprintf("degree=%d", degree), func(b *testing.B) {
+			tr := NewWithOptions(Options{Degree: degree})
+			for j := range keys {
+				tr.ReplaceOrInsert(keys[j], values[j])
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tr.Get(keys[i%size])
+			}
+		})
+	}
+}
+
+func BenchmarkInsertSequentialHint(b *testing.B) {
+	const size = 1_000_000
+	keys := make([][]byte, size)
+	for i := range keys {
+		keys[i] = seqKey(i)
+	}
+	b.Run("NoHint", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tr := New()
+			for _, k := range keys {
+				tr.ReplaceOrInsert(k, k)
+			}
+		}
+	})
+	b.Run("Hint", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tr := New()
+			var hint PathHint
+			for _, k := range keys {
+				tr.ReplaceOrInsertHint(k, k, &hint)
+			}
+		}
+	})
+}
+
 //func BenchmarkInsertRadix(b *testing.B) {
 //	b.StopTimer()
 //	keys, values := perm(benchmarkTreeSize)
@@ -528,29 +1042,6 @@ func BenchmarkDelete(b *testing.B) {
 	}
 }
 
-func BenchmarkGet(b *testing.B) {
-	b.StopTimer()
-	insertP := perm(benchmarkTreeSize)
-	removeP := perm(benchmarkTreeSize)
-	b.StartTimer()
-	i := 0
-	for i < b.N {
-		b.StopTimer()
-		tr := New(*btreeDegree)
-		for _, v := range insertP {
-			tr.ReplaceOrInsert(v)
-		}
-		b.StartTimer()
-		for _, item := range removeP {
-			tr.Get(item)
-			i++
-			if i >= b.N {
-				return
-			}
-		}
-	}
-}
-
 func BenchmarkGetCloneEachTime(b *testing.B) {
 	b.StopTimer()
 	insertP := perm(benchmarkTreeSize)
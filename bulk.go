@@ -0,0 +1,206 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnsorted is returned by BulkLoad when keys are not in ascending order.
+	ErrUnsorted = errors.New("bytebtree: keys are not in ascending order")
+	// ErrDuplicateKey is returned by BulkLoad when a key appears twice.
+	ErrDuplicateKey = errors.New("bytebtree: duplicate key")
+)
+
+// BulkLoad replaces the contents of t with the items produced by iter, which
+// must yield keys in strictly ascending order and return ok == false once it
+// is exhausted.
+//
+// The tree is built bottom-up in a single pass: every node except those on
+// the right edge of the tree is filled to the maximum number of items, and no
+// key is searched for.  If iter yields a nil, out-of-order or duplicate key,
+// BulkLoad returns an error and t is left unchanged.
+func (t *BTree) BulkLoad(iter func() (k, v []byte, ok bool)) error {
+	b := bulkBuilder{cow: t.cow, maxItems: t.maxItems(), minItems: t.minItems()}
+	own := t.cow.own
+	a := t.cow.loadArena()
+	if a != nil {
+		own = a.own
+	}
+	var prev []byte
+	for {
+		k, v, ok := iter()
+		if !ok {
+			break
+		}
+		if err := b.check(prev, k); err != nil {
+			b.abort()
+			return err
+		}
+		k, v = own(k, v)
+		b.add(Item{k, v})
+		prev = k
+	}
+	root, length := b.finish()
+	if t.root != nil {
+		t.root.reset(t.cow)
+	}
+	t.root, t.length = root, length
+	if a != nil {
+		t.cow.arena = a
+	}
+	return nil
+}
+
+// bulkBuilder assembles a tree from items appended in ascending order.
+//
+// levels holds the node under construction at each height, leaves first.  A
+// leaf under construction holds items only; an internal node under
+// construction has one child per item, and is waiting for the child that
+// follows its last item.
+type bulkBuilder struct {
+	cow      *copyOnWriteContext
+	maxItems int
+	minItems int
+	levels   []*node
+	length   int
+}
+
+// check validates that k may follow prev.
+func (b *bulkBuilder) check(prev, k []byte) error {
+	if k == nil {
+		return errors.New("bytebtree: nil key")
+	}
+	if b.length == 0 {
+		return nil
+	}
+	switch c := b.cow.compare(prev, k); {
+	case c == 0:
+		return fmt.Errorf("%w: %x", ErrDuplicateKey, k)
+	case c > 0:
+		return fmt.Errorf("%w: %x after %x", ErrUnsorted, k, prev)
+	}
+	return nil
+}
+
+// add appends item after every item added so far.
+func (b *bulkBuilder) add(item Item) {
+	if len(b.levels) == 0 {
+		b.levels = append(b.levels, b.cow.newNode())
+	}
+	b.length++
+	leaf := b.levels[0]
+	if len(leaf.items) < b.maxItems {
+		leaf.items = append(leaf.items, item)
+		return
+	}
+	// The leaf is full: item separates it from the next leaf.
+	b.levels[0] = b.cow.newNode()
+	b.push(1, item, leaf)
+}
+
+// push hands the completed node done, followed by the separator item, to the
+// node under construction at the given height.
+func (b *bulkBuilder) push(height int, item Item, done *node) {
+	done.recount()
+	if height == len(b.levels) {
+		b.levels = append(b.levels, b.cow.newNode())
+	}
+	n := b.levels[height]
+	n.children = append(n.children, done)
+	if len(n.items) < b.maxItems {
+		n.items = append(n.items, item)
+		return
+	}
+	// n now has all of its children: item separates it from the next node at
+	// this height.
+	b.levels[height] = b.cow.newNode()
+	b.push(height+1, item, n)
+}
+
+// finish closes every node under construction and returns the root of the
+// finished tree along with its number of items.
+//
+// Only the last node at each height can hold fewer than the minimum number of
+// items.  Such a node is topped up from its left sibling, which is always
+// full; this is done from the root down, so that every parent has a left
+// sibling to offer by the time its last child is fixed.
+func (b *bulkBuilder) finish() (*node, int) {
+	if b.length == 0 {
+		b.abort()
+		return nil, 0
+	}
+	top := len(b.levels) - 1
+	for h := 0; h < top; h++ {
+		b.levels[h+1].children = append(b.levels[h+1].children, b.levels[h])
+	}
+	for h := top - 1; h >= 0; h-- {
+		if parent := b.levels[h+1]; len(b.levels[h].items) < b.minItems {
+			parent.balanceChildren(len(parent.items) - 1)
+		}
+	}
+	for _, n := range b.levels {
+		n.recount()
+	}
+	root := b.levels[top]
+	b.levels = nil
+	b.cow.packDirty()
+	return root, b.length
+}
+
+// abort returns every node built so far to the free list.
+func (b *bulkBuilder) abort() {
+	for _, n := range b.levels {
+		n.reset(b.cow)
+	}
+	b.levels = nil
+	b.cow.packDirty()
+}
+
+// balanceChildren redistributes the items of n.children[i] and
+// n.children[i+1], along with the separator n.items[i] between them, so that
+// the two children hold the same number of items give or take one.  Both
+// children must be owned by n's write context, and are opened if packed.
+func (n *node) balanceChildren(i int) {
+	left, right := n.children[i], n.children[i+1]
+	left.open()
+	right.open()
+	all := make(items, 0, len(left.items)+1+len(right.items))
+	all = append(all, left.items...)
+	all = append(all, n.items[i])
+	all = append(all, right.items...)
+	var kids children
+	if len(left.children) > 0 {
+		kids = make(children, 0, len(left.children)+len(right.children))
+		kids = append(kids, left.children...)
+		kids = append(kids, right.children...)
+	}
+	mid := len(all) / 2
+	left.items.truncate(0)
+	left.items = append(left.items, all[:mid]...)
+	n.items[i] = n.cow.detach(all[mid])
+	right.items.truncate(0)
+	right.items = append(right.items, all[mid+1:]...)
+	if kids != nil {
+		left.children.truncate(0)
+		left.children = append(left.children, kids[:mid+1]...)
+		right.children.truncate(0)
+		right.children = append(right.children, kids[mid+1:]...)
+	}
+	left.recount()
+	right.recount()
+}
@@ -0,0 +1,119 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// sliceIter returns a BulkLoad iterator over keys and values.
+func sliceIter(keys, values [][]byte) func() ([]byte, []byte, bool) {
+	i := 0
+	return func() ([]byte, []byte, bool) {
+		if i == len(keys) {
+			return nil, nil, false
+		}
+		i++
+		return keys[i-1], values[i-1], true
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	for _, degree := range []int{2, 3, 5} {
+		for size := 0; size < 300; size++ {
+			keys, values := rang(size)
+			tr := NewWithOptions(Options{Degree: degree})
+			if err := tr.BulkLoad(sliceIter(keys, values)); err != nil {
+				t.Fatalf("degree %d, size %d: %v", degree, size, err)
+			}
+			checkTree(t, tr)
+			got, gotValues := all(tr)
+			if !reflect.DeepEqual(got, keys) || !reflect.DeepEqual(gotValues, values) {
+				t.Fatalf("degree %d, size %d: mismatch", degree, size)
+			}
+			// The loaded tree must stay valid under ordinary writes.
+			more, moreValues := perm(50)
+			for i := range more {
+				tr.ReplaceOrInsert(more[i], moreValues[i])
+			}
+			for i := 0; i < len(keys); i += 2 {
+				tr.Delete(keys[i])
+			}
+			checkTree(t, tr)
+		}
+	}
+}
+
+func TestBulkLoadLarge(t *testing.T) {
+	keys, values := rang(100_000)
+	tr := New()
+	if err := tr.BulkLoad(sliceIter(keys, values)); err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, tr)
+	if tr.Len() != len(keys) {
+		t.Fatalf("len: got %d, want %d", tr.Len(), len(keys))
+	}
+	for i := 0; i < len(keys); i += 997 {
+		if k, _ := tr.GetAt(i); !reflect.DeepEqual(k, keys[i]) {
+			t.Fatalf("getat %d: got %x, want %x", i, k, keys[i])
+		}
+	}
+}
+
+func TestBulkLoadRejects(t *testing.T) {
+	tr := NewWithOptions(Options{Degree: 2})
+	tr.ReplaceOrInsert([]byte("keep"), nil)
+	for _, tc := range []struct {
+		keys []string
+		err  error
+	}{
+		{[]string{"a", "b", "b", "c"}, ErrDuplicateKey},
+		{[]string{"a", "c", "b"}, ErrUnsorted},
+	} {
+		keys := make([][]byte, len(tc.keys))
+		for i, k := range tc.keys {
+			keys[i] = []byte(k)
+		}
+		if err := tr.BulkLoad(sliceIter(keys, make([][]byte, len(keys)))); !errors.Is(err, tc.err) {
+			t.Fatalf("%q: got error %v, want %v", tc.keys, err, tc.err)
+		}
+		if got, _ := all(tr); len(got) != 1 || string(got[0]) != "keep" {
+			t.Fatalf("%q: failed load modified the tree: %q", tc.keys, got)
+		}
+	}
+}
+
+func BenchmarkBulkLoad(b *testing.B) {
+	const size = 1_000_000
+	keys, values := rang(size)
+	b.Run("BulkLoad", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			New().BulkLoad(sliceIter(keys, values))
+		}
+	})
+	b.Run("ReplaceOrInsert", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tr := New()
+			for j := range keys {
+				tr.ReplaceOrInsert(keys[j], values[j])
+			}
+		}
+	})
+}
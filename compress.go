@@ -0,0 +1,230 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import "bytes"
+
+// Key compression.
+//
+// In a tree created with Options.CompressKeys, every leaf is kept packed: the
+// keys of its items are copied into a single buffer that holds the prefix they
+// all share once, followed by the rest of each key, and n.items[i][0] only
+// holds that rest.  Internal nodes hold a small fraction of the items and are
+// left alone.
+//
+// Packed leaves are read in place: find strips the prefix from the search
+// key, and the few readers that hand out keys rebuild them with item or
+// fullItems.  The common writes work on packed leaves too.  A key inserted
+// into a leaf whose prefix it starts with is stored as the rest of the key,
+// outside of the buffer; a removed item gets its full key back from item; a
+// leaf splits into two leaves with the same prefix.  Rebuilding the buffer
+// copies every key of the leaf, so it is only done once a write has moved the
+// leaf's items around or added enough loose keys to it: the leaf is then
+// recorded in its copyOnWriteContext, and packed again by packDirty once the
+// write is done.
+//
+// The other writes, such as merging leaves or stealing an item from a
+// sibling, first open the leaves they modify, replacing their keys by full
+// keys.  The keys of an open leaf share one allocation, so an item that moves
+// from a leaf up into an internal node gets its own copy of its key through
+// detach, rather than keep the whole buffer alive.
+
+// emptyPrefix is the prefix of a packed node whose keys share no prefix.
+var emptyPrefix = []byte{}
+
+// isPacked reports whether n stores its keys relative to n.prefix.
+func (n *node) isPacked() bool {
+	return n.prefix != nil
+}
+
+// item returns n.items[i] with its full key.  The key is a copy if n is
+// packed.
+func (n *node) item(i int) Item {
+	it := n.items[i]
+	if n.isPacked() {
+		k := make([]byte, 0, len(n.prefix)+len(it[0]))
+		k = append(k, n.prefix...)
+		it[0] = append(k, it[0]...)
+	}
+	return it
+}
+
+// fullItems returns the items of n with their full keys.  The result must not
+// be modified, as it is n.items itself if n isn't packed.
+func (n *node) fullItems() items {
+	if !n.isPacked() {
+		return n.items
+	}
+	out := make(items, len(n.items))
+	copy(out, n.items)
+	expandKeys(n.prefix, out)
+	return out
+}
+
+// unpack replaces the keys of n by full keys.
+func (n *node) unpack() {
+	expandKeys(n.prefix, n.items)
+	n.prefix = nil
+	n.loose = 0
+}
+
+// open unpacks n, which must be mutable, before its items are moved around,
+// and records it to be packed again.
+func (n *node) open() {
+	if n.isPacked() {
+		n.unpack()
+		n.cow.dirty = append(n.cow.dirty, n)
+	}
+}
+
+// expandKeys prepends prefix to the key of every item in s.  The new keys
+// share a single allocation.
+func expandKeys(prefix []byte, s items) {
+	size := 0
+	for _, it := range s {
+		size += len(prefix) + len(it[0])
+	}
+	buf := make([]byte, 0, size)
+	for i := range s {
+		start := len(buf)
+		buf = append(buf, prefix...)
+		buf = append(buf, s[i][0]...)
+		s[i][0] = buf[start:len(buf):len(buf)]
+	}
+}
+
+// insertLeaf inserts item at index i of the leaf n, which must be mutable.
+// If n is packed and the key of item starts with its prefix, n stays packed
+// and only the rest of the key is stored, as a loose key; n is recorded to be
+// packed again once a quarter of its keys are loose.  Otherwise n is opened.
+func (n *node) insertLeaf(i int, item Item) {
+	if n.isPacked() {
+		if bytes.HasPrefix(item[0], n.prefix) {
+			item[0] = item[0][len(n.prefix):]
+			if n.loose++; n.loose == len(n.items)/4+1 {
+				n.cow.dirty = append(n.cow.dirty, n)
+			}
+		} else {
+			n.open()
+		}
+	}
+	n.items.insertAt(i, item)
+}
+
+// removeLeaf removes the item at index i of the leaf n, which must be
+// mutable, and returns it with its full key.
+func (n *node) removeLeaf(i int) Item {
+	it := n.item(i)
+	n.items.removeAt(i)
+	return it
+}
+
+// takeItem returns n.items[i] with a key of its own, to move it out of the
+// leaf n, if the tree compresses keys.
+func (n *node) takeItem(i int) Item {
+	if n.isPacked() {
+		return n.item(i)
+	}
+	return n.cow.detach(n.items[i])
+}
+
+// pack copies the keys of the leaf n into a single buffer, storing their
+// common prefix once.  If n is already packed, its loose keys are moved into
+// the new buffer, and its prefix grows if its keys now share more of it.
+// Items are sorted, so the prefix shared by the first and last keys is shared
+// by all of them.
+func (n *node) pack() {
+	if len(n.children) > 0 {
+		return
+	}
+	n.loose = 0
+	if len(n.items) == 0 {
+		// An empty node is packed too, so that it is recorded as dirty when
+		// it is next opened.
+		n.prefix = emptyPrefix
+		return
+	}
+	old := n.prefix
+	first, last := n.items[0][0], n.items[len(n.items)-1][0]
+	d := 0
+	for d < len(first) && d < len(last) && first[d] == last[d] {
+		d++
+	}
+	lp := len(old) + d
+	size := lp
+	for _, it := range n.items {
+		size += len(it[0]) - d
+	}
+	buf := make([]byte, 0, size)
+	buf = append(buf, old...)
+	buf = append(buf, first[:d]...)
+	prefix := buf[:lp:lp]
+	for i := range n.items {
+		start := len(buf)
+		buf = append(buf, n.items[i][0][d:]...)
+		n.items[i][0] = buf[start:len(buf):len(buf)]
+	}
+	n.prefix = prefix
+}
+
+// trimPrefix returns the part of key that follows n.prefix.  If key doesn't
+// start with n.prefix, ok is false and i is where key would be inserted in
+// n.items: before every item or after every item.
+func (n *node) trimPrefix(key []byte) (rest []byte, i int, ok bool) {
+	lp := len(n.prefix)
+	if len(key) >= lp && bytes.Equal(key[:lp], n.prefix) {
+		return key[lp:], 0, true
+	}
+	if bytes.Compare(key, n.prefix) < 0 {
+		return nil, 0, false
+	}
+	return nil, len(n.items), false
+}
+
+// detach returns it with a key of its own, if c compresses keys.
+func (c *copyOnWriteContext) detach(it Item) Item {
+	if c.compress {
+		k := make([]byte, len(it[0]))
+		copy(k, it[0])
+		it[0] = k
+	}
+	return it
+}
+
+// forgetDirty stops recording n as a node to pack: n is being freed, or is a
+// copy of a node that was already packed.  Once in the free list, n can be
+// taken by any tree sharing the list, and packDirty must not touch it anymore.
+func (c *copyOnWriteContext) forgetDirty(n *node) {
+	for i := len(c.dirty) - 1; i >= 0; i-- {
+		if c.dirty[i] == n {
+			last := len(c.dirty) - 1
+			c.dirty[i] = c.dirty[last]
+			c.dirty[last] = nil
+			c.dirty = c.dirty[:last]
+		}
+	}
+}
+
+// packDirty packs every leaf recorded as modified since the last call, that
+// c still owns.
+func (c *copyOnWriteContext) packDirty() {
+	for i, n := range c.dirty {
+		if n.cow == c {
+			n.pack()
+		}
+		c.dirty[i] = nil
+	}
+	c.dirty = c.dirty[:0]
+}
@@ -0,0 +1,264 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// bucketKey returns a 40-byte key made of an 8-byte bucket prefix followed by
+// 32 random bytes, the layout CompressKeys is meant for.
+func bucketKey(bucket int) []byte {
+	k := make([]byte, 40)
+	copy(k, fmt.Sprintf("bkt%05d", bucket))
+	rand.Read(k[8:])
+	return k
+}
+
+// checkPacked verifies that every leaf of tr is packed, and that no internal
+// node is.
+func checkPacked(t *testing.T, tr *BTree) {
+	t.Helper()
+	var walk func(n *node)
+	walk = func(n *node) {
+		if leaf := len(n.children) == 0; n.isPacked() != leaf {
+			t.Fatalf("leaf %v with %d items has packed %v", leaf, len(n.items), n.isPacked())
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	if tr.root != nil {
+		walk(tr.root)
+	}
+}
+
+func TestCompressKeys(t *testing.T) {
+	for _, degree := range []int{2, 3, 16} {
+		t.Run(fmt.Sprintf("degree=%d", degree), func(t *testing.T) {
+			tr := NewWithOptions(Options{Degree: degree, CompressKeys: true})
+			want := map[string][]byte{}
+			var keys [][]byte
+			for i := 0; i < 2000; i++ {
+				k := bucketKey(rand.Intn(4))
+				v := []byte(fmt.Sprint(i))
+				tr.ReplaceOrInsert(k, v)
+				want[string(k)] = v
+				keys = append(keys, k)
+				if i%3 == 0 {
+					k := keys[rand.Intn(len(keys))]
+					tr.Delete(k)
+					delete(want, string(k))
+				}
+			}
+			checkTree(t, tr)
+			checkPacked(t, tr)
+			if tr.Len() != len(want) {
+				t.Fatalf("tree holds %d items, want %d", tr.Len(), len(want))
+			}
+			for k, v := range want {
+				if got, ok := tr.Get([]byte(k)); !ok || !bytes.Equal(got, v) {
+					t.Fatalf("Get(%x) = %q, %v, want %q", k, got, ok, v)
+				}
+			}
+			gotKeys, _ := all(tr)
+			for i, k := range gotKeys {
+				if _, ok := want[string(k)]; !ok {
+					t.Fatalf("iteration returned unknown key %x", k)
+				}
+				if k2, _ := tr.GetAt(i); !bytes.Equal(k2, k) {
+					t.Fatalf("GetAt(%d) = %x, want %x", i, k2, k)
+				}
+			}
+			if min, _ := tr.Min(); !bytes.Equal(min, gotKeys[0]) {
+				t.Fatalf("Min = %x, want %x", min, gotKeys[0])
+			}
+			c := tr.Cursor()
+			for k, _ := c.Seek([]byte("bkt00002")); k != nil; k, _ = c.Next() {
+				if !bytes.HasPrefix(k, []byte("bkt0000")) || bytes.Compare(k, []byte("bkt00002")) < 0 {
+					t.Fatalf("cursor returned %x", k)
+				}
+			}
+			if n, m := tr.CountPrefix([]byte("bkt00001")), tr.CountRange([]byte("bkt00001"), []byte("bkt00002")); n != m {
+				t.Fatalf("CountPrefix = %d, CountRange = %d", n, m)
+			}
+
+			clone := tr.Clone()
+			removed := tr.DeletePrefix([]byte("bkt00001"))
+			checkTree(t, tr)
+			checkPacked(t, tr)
+			if tr.Len()+removed != clone.Len() {
+				t.Fatalf("DeletePrefix removed %d of %d items, %d left", removed, clone.Len(), tr.Len())
+			}
+			checkTree(t, clone)
+			if got, _ := all(clone); !reflect.DeepEqual(got, gotKeys) {
+				t.Fatalf("writes to a tree changed its clone")
+			}
+		})
+	}
+}
+
+func TestCompressKeysLoad(t *testing.T) {
+	keys, values := rang(1000)
+	tr := NewWithOptions(Options{Degree: 3, CompressKeys: true})
+	if err := tr.BulkLoad(sliceIter(keys, values)); err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, tr)
+	checkPacked(t, tr)
+	var buf bytes.Buffer
+	if _, err := tr.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	tr2 := NewWithOptions(Options{Degree: 5, CompressKeys: true})
+	if _, err := tr2.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkTree(t, tr2)
+	checkPacked(t, tr2)
+	got, gotValues := all(tr2)
+	if !reflect.DeepEqual(got, keys) || !reflect.DeepEqual(gotValues, values) {
+		t.Fatalf("snapshot round trip mismatch")
+	}
+}
+
+// Writes leave packed leaves packed where they can, so check a mix of them
+// against a tree without CompressKeys, with keys that don't always share the
+// prefix of the leaf they land in.
+func TestCompressKeysWrites(t *testing.T) {
+	for _, degree := range []int{2, 3, 8} {
+		t.Run(fmt.Sprintf("degree=%d", degree), func(t *testing.T) {
+			plain := NewWithOptions(Options{Degree: degree})
+			tr := NewWithOptions(Options{Degree: degree, CompressKeys: true})
+			key := func() []byte {
+				if rand.Intn(10) == 0 {
+					k := make([]byte, 1+rand.Intn(12))
+					rand.Read(k)
+					return k
+				}
+				return bucketKey(rand.Intn(3))
+			}
+			var keys [][]byte
+			var clones []*BTree
+			var cloneKeys [][][]byte
+			for i := 0; i < 5000; i++ {
+				var k []byte
+				if len(keys) > 0 && rand.Intn(2) == 0 {
+					k = keys[rand.Intn(len(keys))]
+				} else {
+					k = key()
+					keys = append(keys, k)
+				}
+				v := []byte(fmt.Sprint(i))
+				switch op := rand.Intn(10); {
+				case op < 5:
+					k1, v1 := plain.ReplaceOrInsert(k, v)
+					k2, v2 := tr.ReplaceOrInsert(k, v)
+					if !bytes.Equal(k1, k2) || !bytes.Equal(v1, v2) {
+						t.Fatalf("ReplaceOrInsert(%x) = %x, %q, want %x, %q", k, k2, v2, k1, v1)
+					}
+				case op < 8:
+					k1, v1 := plain.Delete(k)
+					k2, v2 := tr.Delete(k)
+					if !bytes.Equal(k1, k2) || !bytes.Equal(v1, v2) {
+						t.Fatalf("Delete(%x) = %x, %q, want %x, %q", k, k2, v2, k1, v1)
+					}
+				case op < 9:
+					plain.InsertIfAbsent(k, v)
+					tr.InsertIfAbsent(k, v)
+				default:
+					k1, _ := plain.DeleteMin()
+					k2, _ := tr.DeleteMin()
+					if !bytes.Equal(k1, k2) {
+						t.Fatalf("DeleteMin = %x, want %x", k2, k1)
+					}
+				}
+				if i%500 == 0 {
+					clones = append(clones, tr.Clone())
+					got, _ := all(tr)
+					cloneKeys = append(cloneKeys, got)
+				}
+			}
+			checkTree(t, tr)
+			checkPacked(t, tr)
+			want, wantValues := all(plain)
+			got, gotValues := all(tr)
+			if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(gotValues, wantValues) {
+				t.Fatalf("tree holds %d items, want %d", len(got), len(want))
+			}
+			for i, c := range clones {
+				checkTree(t, c)
+				checkPacked(t, c)
+				if got, _ := all(c); !reflect.DeepEqual(got, cloneKeys[i]) {
+					t.Fatalf("clone %d changed", i)
+				}
+			}
+		})
+	}
+}
+
+// Trees sharing a FreeList may write concurrently, so nodes a write frees
+// must be left alone once returned to the list.  Run with -race.
+func TestCompressKeysSharedFreeList(t *testing.T) {
+	fl := NewFreeList(64)
+	var wg sync.WaitGroup
+	trees := make([]*BTree, 4)
+	for i := range trees {
+		tr := NewWithOptions(Options{Degree: 3, FreeList: fl, CompressKeys: true})
+		trees[i] = tr
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var keys [][]byte
+			for j := 0; j < 3000; j++ {
+				k := bucketKey(rand.Intn(4))
+				tr.ReplaceOrInsert(k, k)
+				keys = append(keys, k)
+				switch j % 10 {
+				case 3:
+					tr.Delete(keys[rand.Intn(len(keys))])
+				case 7:
+					tr.DeleteRange(bucketKey(rand.Intn(4)), bucketKey(4))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for _, tr := range trees {
+		checkTree(t, tr)
+		checkPacked(t, tr)
+	}
+}
+
+func TestCompressKeysBadOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{CompressKeys: true, Compare: reverseCompare},
+		{CompressKeys: true, ArenaChunkSize: 1024},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewWithOptions(%+v) didn't panic", opts)
+				}
+			}()
+			NewWithOptions(opts)
+		}()
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+// Cursor is a stateful iterator over the items of a tree.
+//
+// A Cursor keeps the path from the root to its current item as an explicit
+// stack, so it can be paused at any point and moved in either direction
+// without searching from the root again.  Moving past either end of the tree
+// leaves the cursor unpositioned until First, Last or Seek is called.
+//
+// A Cursor reads the nodes of its tree directly: any write to the tree
+// invalidates the cursor's position, and the next call must be First, Last or
+// Seek.  To walk a tree while it is being written, create the cursor on a
+// Clone.
+type Cursor struct {
+	t     *BTree
+	stack []cursorFrame
+}
+
+// cursorFrame is one level of a cursor's path.  The top frame of the stack
+// points at the current item n.items[i]; every other frame records that the
+// path continues into n.children[i].
+type cursorFrame struct {
+	n *node
+	i int
+}
+
+// Cursor returns a new, unpositioned cursor over t.
+func (t *BTree) Cursor() *Cursor {
+	return &Cursor{t: t}
+}
+
+// Current returns the key and value the cursor points at.  It returns nils if
+// the cursor is unpositioned.
+func (c *Cursor) Current() ([]byte, []byte) {
+	if len(c.stack) == 0 {
+		return nil, nil
+	}
+	top := c.stack[len(c.stack)-1]
+	it := top.n.item(top.i)
+	return it[0], it[1]
+}
+
+// First moves the cursor to the smallest item in the tree and returns it.  It
+// returns nils if the tree is empty.
+func (c *Cursor) First() ([]byte, []byte) {
+	c.stack = c.stack[:0]
+	n := c.t.root
+	if n == nil || len(n.items) == 0 {
+		return nil, nil
+	}
+	c.pushFirst(n)
+	return c.Current()
+}
+
+// Last moves the cursor to the largest item in the tree and returns it.  It
+// returns nils if the tree is empty.
+func (c *Cursor) Last() ([]byte, []byte) {
+	c.stack = c.stack[:0]
+	n := c.t.root
+	if n == nil || len(n.items) == 0 {
+		return nil, nil
+	}
+	c.pushLast(n)
+	return c.Current()
+}
+
+// Seek moves the cursor to the smallest item whose key is greater than or
+// equal to key and returns it.  It returns nils if there is no such item.
+func (c *Cursor) Seek(key []byte) ([]byte, []byte) {
+	c.stack = c.stack[:0]
+	n := c.t.root
+	if n == nil || len(n.items) == 0 {
+		return nil, nil
+	}
+	seek := Item{key}
+	for {
+		i, found := n.find(&seek, nil, 0)
+		if found {
+			c.stack = append(c.stack, cursorFrame{n, i})
+			return c.Current()
+		}
+		if len(n.children) == 0 {
+			if i < len(n.items) {
+				c.stack = append(c.stack, cursorFrame{n, i})
+				return c.Current()
+			}
+			// Every item in this leaf is smaller than key: the answer is the
+			// next item after the leaf's last one.
+			c.stack = append(c.stack, cursorFrame{n, i - 1})
+			return c.Next()
+		}
+		c.stack = append(c.stack, cursorFrame{n, i})
+		n = n.children[i]
+	}
+}
+
+// Next moves the cursor to the next item in ascending order and returns it.
+// It returns nils, leaving the cursor unpositioned, if there is no next item
+// or the cursor was unpositioned.
+func (c *Cursor) Next() ([]byte, []byte) {
+	if len(c.stack) == 0 {
+		return nil, nil
+	}
+	top := &c.stack[len(c.stack)-1]
+	if len(top.n.children) > 0 {
+		top.i++
+		c.pushFirst(top.n.children[top.i])
+		return c.Current()
+	}
+	top.i++
+	if top.i < len(top.n.items) {
+		return c.Current()
+	}
+	for {
+		c.stack = c.stack[:len(c.stack)-1]
+		if len(c.stack) == 0 {
+			return nil, nil
+		}
+		top = &c.stack[len(c.stack)-1]
+		if top.i < len(top.n.items) {
+			return c.Current()
+		}
+	}
+}
+
+// Prev moves the cursor to the previous item in ascending order and returns
+// it.  It returns nils, leaving the cursor unpositioned, if there is no
+// previous item or the cursor was unpositioned.
+func (c *Cursor) Prev() ([]byte, []byte) {
+	if len(c.stack) == 0 {
+		return nil, nil
+	}
+	top := &c.stack[len(c.stack)-1]
+	if len(top.n.children) > 0 {
+		c.pushLast(top.n.children[top.i])
+		return c.Current()
+	}
+	top.i--
+	if top.i >= 0 {
+		return c.Current()
+	}
+	for {
+		c.stack = c.stack[:len(c.stack)-1]
+		if len(c.stack) == 0 {
+			return nil, nil
+		}
+		top = &c.stack[len(c.stack)-1]
+		if top.i > 0 {
+			top.i--
+			return c.Current()
+		}
+	}
+}
+
+// pushFirst extends the path down the left spine of the subtree rooted at n.
+func (c *Cursor) pushFirst(n *node) {
+	for {
+		c.stack = append(c.stack, cursorFrame{n, 0})
+		if len(n.children) == 0 {
+			return
+		}
+		n = n.children[0]
+	}
+}
+
+// pushLast extends the path down the right spine of the subtree rooted at n.
+func (c *Cursor) pushLast(n *node) {
+	for len(n.children) > 0 {
+		c.stack = append(c.stack, cursorFrame{n, len(n.children) - 1})
+		n = n.children[len(n.children)-1]
+	}
+	c.stack = append(c.stack, cursorFrame{n, len(n.items) - 1})
+}
@@ -0,0 +1,132 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	for _, degree := range []int{2, 3, 16} {
+		tr := NewWithOptions(Options{Degree: degree})
+		keys, values := perm(1000)
+		for i := range keys {
+			tr.ReplaceOrInsert(keys[i], values[i])
+		}
+		want, wantValues := rang0(keys, values)
+
+		c := tr.Cursor()
+		if k, _ := c.Current(); k != nil {
+			t.Fatalf("unpositioned cursor returned %x", k)
+		}
+		var got, gotValues [][]byte
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			got = append(got, k)
+			gotValues = append(gotValues, v)
+		}
+		if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(gotValues, wantValues) {
+			t.Fatalf("degree %d: forward walk mismatch", degree)
+		}
+		got = got[:0]
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			got = append(got, k)
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], want[len(want)-1-i]) {
+				t.Fatalf("degree %d: backward walk mismatch at %d", degree, i)
+			}
+		}
+
+		// Switching direction mid-walk must retrace the same items.
+		c.Seek(want[500])
+		for i := 501; i < 700; i++ {
+			if k, _ := c.Next(); !bytes.Equal(k, want[i]) {
+				t.Fatalf("degree %d: next %d: got %x, want %x", degree, i, k, want[i])
+			}
+		}
+		for i := 698; i >= 300; i-- {
+			if k, _ := c.Prev(); !bytes.Equal(k, want[i]) {
+				t.Fatalf("degree %d: prev %d: got %x, want %x", degree, i, k, want[i])
+			}
+		}
+		if k, _ := c.Current(); !bytes.Equal(k, want[300]) {
+			t.Fatalf("degree %d: current: got %x, want %x", degree, k, want[300])
+		}
+	}
+}
+
+func TestCursorSeek(t *testing.T) {
+	tr := NewWithOptions(Options{Degree: 2})
+	for i := byte(0); i < 100; i++ {
+		tr.ReplaceOrInsert([]byte{i * 2}, []byte{i})
+	}
+	c := tr.Cursor()
+	for i := 0; i < 200; i++ {
+		k, _ := c.Seek([]byte{byte(i)})
+		want := byte(i)
+		if i%2 == 1 {
+			want++
+		}
+		if i == 199 {
+			if k != nil {
+				t.Fatalf("seek past the end returned %x", k)
+			}
+			continue
+		}
+		if !bytes.Equal(k, []byte{want}) {
+			t.Fatalf("seek %d: got %x, want %x", i, k, want)
+		}
+	}
+	if k, _ := c.Next(); k != nil {
+		t.Fatalf("next on an unpositioned cursor returned %x", k)
+	}
+	if k, _ := c.Seek(nil); !bytes.Equal(k, []byte{0}) {
+		t.Fatalf("seek nil: got %x", k)
+	}
+	if k, _ := c.Prev(); k != nil {
+		t.Fatalf("prev before the first item returned %x", k)
+	}
+
+	empty := New().Cursor()
+	if k, _ := empty.First(); k != nil {
+		t.Fatalf("first on empty tree returned %x", k)
+	}
+	if k, _ := empty.Seek([]byte{1}); k != nil {
+		t.Fatalf("seek on empty tree returned %x", k)
+	}
+}
+
+func TestCursorClone(t *testing.T) {
+	tr := NewWithOptions(Options{Degree: 3})
+	keys, values := perm(300)
+	for i := range keys {
+		tr.ReplaceOrInsert(keys[i], values[i])
+	}
+	want, _ := rang0(keys, values)
+	snap := tr.Clone()
+	c := snap.Cursor()
+	k, _ := c.First()
+	for i := range keys {
+		tr.Delete(keys[i])
+	}
+	for i := 0; k != nil; i++ {
+		if !bytes.Equal(k, want[i]) {
+			t.Fatalf("snapshot walk %d: got %x, want %x", i, k, want[i])
+		}
+		k, _ = c.Next()
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import "bytes"
+
+// DiffKind is the kind of difference Diff reports for a key.
+type DiffKind int
+
+const (
+	// DiffInsert reports a key only present in the second tree.
+	DiffInsert DiffKind = iota
+	// DiffDelete reports a key only present in the first tree.
+	DiffDelete
+	// DiffUpdate reports a key present in both trees with different values.
+	DiffUpdate
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffInsert:
+		return "insert"
+	case DiffDelete:
+		return "delete"
+	case DiffUpdate:
+		return "update"
+	}
+	return "DiffKind(?)"
+}
+
+// Diff calls fn for every key whose item differs between a and b, in
+// ascending key order, until fn returns false.  oldV is the value in a and
+// newV the value in b; the one of a missing item is nil.
+//
+// Subtrees that a and b share, as they do after one was cloned from the
+// other, are skipped without being read, so diffing two clones costs about
+// O(k log n) for k writes made since the clone.  Trees that share nothing are
+// walked in full.  a and b must order keys the same way.
+func Diff(a, b *BTree, fn func(kind DiffKind, k, oldV, newV []byte) bool) {
+	compare := a.cow.compare
+	var sa, sb diffStream
+	sa.push(a.root)
+	sb.push(b.root)
+	for {
+		ta, okA := sa.peek()
+		tb, okB := sb.peek()
+		switch {
+		case !okA && !okB:
+			return
+		case !okB:
+			if ta.child != nil {
+				sa.expand()
+				continue
+			}
+			sa.next()
+			if !fn(DiffDelete, ta.item[0], ta.item[1], nil) {
+				return
+			}
+		case !okA:
+			if tb.child != nil {
+				sb.expand()
+				continue
+			}
+			sb.next()
+			if !fn(DiffInsert, tb.item[0], nil, tb.item[1]) {
+				return
+			}
+		case ta.child != nil && tb.child != nil:
+			if ta.child == tb.child {
+				sa.next()
+				sb.next()
+				continue
+			}
+			// Expand the subtree that starts first, or the larger one, which
+			// may contain the other.
+			switch c := compare(min(ta.child)[0], min(tb.child)[0]); {
+			case c < 0:
+				sa.expand()
+			case c > 0:
+				sb.expand()
+			default:
+				ca, cb := ta.child.count, tb.child.count
+				if ca >= cb {
+					sa.expand()
+				}
+				if cb >= ca {
+					sb.expand()
+				}
+			}
+		case ta.child != nil:
+			// An item sorting before every key of the other side's subtree
+			// can be reported without opening the subtree.
+			if compare(tb.item[0], min(ta.child)[0]) >= 0 {
+				sa.expand()
+				continue
+			}
+			sb.next()
+			if !fn(DiffInsert, tb.item[0], nil, tb.item[1]) {
+				return
+			}
+		case tb.child != nil:
+			if compare(ta.item[0], min(tb.child)[0]) >= 0 {
+				sb.expand()
+				continue
+			}
+			sa.next()
+			if !fn(DiffDelete, ta.item[0], ta.item[1], nil) {
+				return
+			}
+		default:
+			switch c := compare(ta.item[0], tb.item[0]); {
+			case c < 0:
+				sa.next()
+				if !fn(DiffDelete, ta.item[0], ta.item[1], nil) {
+					return
+				}
+			case c > 0:
+				sb.next()
+				if !fn(DiffInsert, tb.item[0], nil, tb.item[1]) {
+					return
+				}
+			default:
+				sa.next()
+				sb.next()
+				if !bytes.Equal(ta.item[1], tb.item[1]) {
+					if !fn(DiffUpdate, ta.item[0], ta.item[1], tb.item[1]) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// diffStream walks a tree as a sequence of tokens, each either an item or a
+// whole subtree.  A subtree can be skipped as a single token, or expanded into
+// its own tokens.
+type diffStream struct {
+	stack []diffFrame
+}
+
+// diffFrame is a node being walked.  In a leaf, token i is item i; in an
+// internal node, tokens alternate between children and items, so token i is
+// child i/2 if i is even and item i/2 if it is odd.
+type diffFrame struct {
+	n *node
+	i int
+}
+
+// diffToken is either a subtree or an item.
+type diffToken struct {
+	child *node
+	item  Item
+}
+
+func (s *diffStream) push(n *node) {
+	if n != nil && len(n.items) > 0 {
+		s.stack = append(s.stack, diffFrame{n, 0})
+	}
+}
+
+// peek returns the current token, or false once the tree is exhausted.
+func (s *diffStream) peek() (diffToken, bool) {
+	for len(s.stack) > 0 {
+		top := s.stack[len(s.stack)-1]
+		if len(top.n.children) == 0 {
+			if top.i < len(top.n.items) {
+				return diffToken{item: top.n.item(top.i)}, true
+			}
+		} else if top.i <= 2*len(top.n.items) {
+			if top.i%2 == 0 {
+				return diffToken{child: top.n.children[top.i/2]}, true
+			}
+			return diffToken{item: top.n.item(top.i / 2)}, true
+		}
+		s.stack = s.stack[:len(s.stack)-1]
+	}
+	return diffToken{}, false
+}
+
+// next moves past the current token.
+func (s *diffStream) next() {
+	s.stack[len(s.stack)-1].i++
+}
+
+// expand replaces the current token, which must be a subtree, by its tokens.
+func (s *diffStream) expand() {
+	top := &s.stack[len(s.stack)-1]
+	child := top.n.children[top.i/2]
+	top.i++
+	s.push(child)
+}
@@ -0,0 +1,140 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+type diffEntry struct {
+	kind          DiffKind
+	k, oldV, newV string
+}
+
+// diffAll returns the differences Diff reports between a and b.
+func diffAll(a, b *BTree) (out []diffEntry) {
+	Diff(a, b, func(kind DiffKind, k, oldV, newV []byte) bool {
+		out = append(out, diffEntry{kind, string(k), string(oldV), string(newV)})
+		return true
+	})
+	return out
+}
+
+// naiveDiff computes the differences between a and b from all of their items.
+func naiveDiff(a, b *BTree) (out []diffEntry) {
+	ka, va := all(a)
+	kb, vb := all(b)
+	for len(ka) > 0 || len(kb) > 0 {
+		switch {
+		case len(kb) == 0 || len(ka) > 0 && bytes.Compare(ka[0], kb[0]) < 0:
+			out = append(out, diffEntry{DiffDelete, string(ka[0]), string(va[0]), ""})
+			ka, va = ka[1:], va[1:]
+		case len(ka) == 0 || bytes.Compare(ka[0], kb[0]) > 0:
+			out = append(out, diffEntry{DiffInsert, string(kb[0]), "", string(vb[0])})
+			kb, vb = kb[1:], vb[1:]
+		default:
+			if !bytes.Equal(va[0], vb[0]) {
+				out = append(out, diffEntry{DiffUpdate, string(ka[0]), string(va[0]), string(vb[0])})
+			}
+			ka, va, kb, vb = ka[1:], va[1:], kb[1:], vb[1:]
+		}
+	}
+	return out
+}
+
+func TestDiff(t *testing.T) {
+	const size = 2000
+	r := rand.New(rand.NewSource(1))
+	for _, opts := range []Options{{Degree: 2}, {Degree: 3}, {Degree: 32}, {Degree: 3, CompressKeys: true}} {
+		for _, writes := range []int{0, 1, 10, 100, 1000} {
+			t.Run(fmt.Sprintf("degree=%d/compress=%v/writes=%d", opts.Degree, opts.CompressKeys, writes), func(t *testing.T) {
+				a := NewWithOptions(opts)
+				for i := 0; i < size; i += 2 {
+					a.ReplaceOrInsert(seqKey(i), []byte("a"))
+				}
+				b := a.Clone()
+				for i := 0; i < writes; i++ {
+					k := seqKey(r.Intn(size + 10))
+					switch r.Intn(3) {
+					case 0:
+						b.Delete(k)
+					case 1:
+						b.ReplaceOrInsert(k, []byte("b"))
+					case 2:
+						b.DeleteRange(k, seqKey(r.Intn(size)))
+					}
+				}
+				got, want := diffAll(a, b), naiveDiff(a, b)
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("got %d differences %v, want %d %v", len(got), got, len(want), want)
+				}
+				got, want = diffAll(b, a), naiveDiff(b, a)
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("reversed: got %d differences %v, want %d %v", len(got), got, len(want), want)
+				}
+			})
+		}
+	}
+}
+
+func TestDiffUnrelated(t *testing.T) {
+	a, b := seqTree(3, 0, 100), seqTree(4, 50, 150)
+	if got, want := diffAll(a, b), naiveDiff(a, b); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := diffAll(a, New()); len(got) != 100 {
+		t.Fatalf("got %d differences with an empty tree, want 100", len(got))
+	}
+	if got := diffAll(a, a); len(got) != 0 {
+		t.Fatalf("got %d differences of a tree with itself", len(got))
+	}
+	n := 0
+	Diff(a, b, func(kind DiffKind, k, oldV, newV []byte) bool {
+		n++
+		return n < 3
+	})
+	if n != 3 {
+		t.Fatalf("Diff went on for %d calls after fn returned false", n-3)
+	}
+}
+
+func BenchmarkDiff(b *testing.B) {
+	const size = 1_000_000
+	tr := NewWithOptions(Options{Degree: 32})
+	for i := 0; i < size; i++ {
+		tr.ReplaceOrInsert(seqKey(i), nil)
+	}
+	for _, writes := range []int{1, 100, 10000} {
+		b.Run(fmt.Sprintf("writes=%d", writes), func(b *testing.B) {
+			clone := tr.Clone()
+			r := rand.New(rand.NewSource(int64(writes)))
+			for i := 0; i < writes; i++ {
+				clone.ReplaceOrInsert(seqKey(r.Intn(size)), []byte("x"))
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n := 0
+				Diff(tr, clone, func(kind DiffKind, k, oldV, newV []byte) bool {
+					n++
+					return true
+				})
+			}
+		})
+	}
+}
@@ -14,6 +14,6 @@
 
 module github.com/AskAlexSharov/bytebtree
 
-go 1.13
+go 1.18
 
 require github.com/hashicorp/go-immutable-radix v1.3.1
@@ -0,0 +1,95 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+// SplitAt returns a tree holding the items of t whose keys sort before pivot,
+// and a tree holding the others.  t is left unchanged.
+//
+// SplitAt costs O(log n): the trees returned share every node of t but those
+// along the path to pivot, and t gets a new write context, as Clone does, so
+// that none of them writes to the shared nodes in place.
+func (t *BTree) SplitAt(pivot []byte) (left, right *BTree) {
+	t.disown()
+	left, right = t.derive(), t.derive()
+	if root, h := subtree(t.root); root != nil {
+		key := Item{pivot}
+		left.root, _, right.root, _ = left.splicer().splitBefore(root, h, &key)
+	}
+	for _, out := range []*BTree{left, right} {
+		if out.root != nil {
+			out.length = out.root.count
+		}
+	}
+	// The nodes split created on the right side belong to left's write
+	// context, which packs them.
+	left.cow.packDirty()
+	return left, right
+}
+
+// Join returns a tree holding the items of left followed by those of right.
+// Every key of left must sort before every key of right, or Join panics.
+//
+// Like SplitAt, Join costs O(log n), sharing the nodes of both trees, which
+// get new write contexts.  left and right must be compatible, as for Union.
+func Join(left, right *BTree) *BTree {
+	checkCompatible(left, right)
+	l, hl := subtree(left.root)
+	r, hr := subtree(right.root)
+	if l != nil && r != nil && left.cow.compare(max(l)[0], min(r)[0]) >= 0 {
+		panic("bytebtree: Join of trees whose keys overlap")
+	}
+	left.disown()
+	right.disown()
+	out := left.derive()
+	if out.cow.arena != nil && right.cow.arena != nil {
+		out.cow.arena.live += right.cow.arena.live
+	}
+	out.root, _ = out.splicer().concat(l, hl, r, hr)
+	if out.root != nil {
+		out.length = out.root.count
+	}
+	out.cow.packDirty()
+	return out
+}
+
+// derive returns an empty tree with the options of t, to build out of nodes
+// shared with t.
+func (t *BTree) derive() *BTree {
+	cow := *t.cow
+	cow.dirty = nil
+	if cow.arena != nil {
+		// The new tree holds at most the bytes of t, all of them live;
+		// counting them exactly would mean reading every item.
+		cow.arena = cow.arena.fork()
+		cow.arena.dead = 0
+	}
+	return &BTree{degree: t.degree, cow: &cow}
+}
+
+// checkCompatible panics unless the nodes of a and b can be mixed in a tree.
+func checkCompatible(a, b *BTree) {
+	if a.degree != b.degree || a.cow.compress != b.cow.compress {
+		panic("bytebtree: trees with different Degree or CompressKeys")
+	}
+}
+
+// subtree returns the subtree rooted at n, as the splicer expects it: nil if
+// it holds no items.
+func subtree(n *node) (*node, int) {
+	if n == nil || len(n.items) == 0 {
+		return nil, 0
+	}
+	return n, height(n)
+}
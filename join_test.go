@@ -0,0 +1,101 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSplitAt(t *testing.T) {
+	for _, opts := range []Options{{Degree: 2}, {Degree: 3}, {Degree: 5, CompressKeys: true}} {
+		for iter := 0; iter < 100; iter++ {
+			size := rand.Intn(500)
+			at := rand.Intn(size + 1)
+			tr := NewWithOptions(opts)
+			for i := 0; i < size; i++ {
+				tr.ReplaceOrInsert(seqKey(i), nil)
+			}
+			left, right := tr.SplitAt(seqKey(at))
+			checkSeq(t, left, 0, at)
+			checkSeq(t, right, at, size)
+			if opts.CompressKeys {
+				checkPacked(t, left)
+				checkPacked(t, right)
+			}
+
+			// The three trees share nodes, but each writes to its own.
+			left.DeleteRange(nil, seqKey(size))
+			right.ReplaceOrInsert(seqKey(size), nil)
+			from := 0
+			if size > 0 {
+				tr.DeleteMin()
+				from = 1
+			}
+			checkSeq(t, left, 0, 0)
+			checkSeq(t, right, at, size+1)
+			checkSeq(t, tr, from, size)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	for _, opts := range []Options{{Degree: 2}, {Degree: 3}, {Degree: 5, CompressKeys: true}} {
+		for iter := 0; iter < 100; iter++ {
+			mid := rand.Intn(400)
+			end := mid + rand.Intn(400)
+			l, r := NewWithOptions(opts), NewWithOptions(opts)
+			for i := 0; i < end; i++ {
+				if i < mid {
+					l.ReplaceOrInsert(seqKey(i), nil)
+				} else {
+					r.ReplaceOrInsert(seqKey(i), nil)
+				}
+			}
+			joined := Join(l, r)
+			checkSeq(t, joined, 0, end)
+			if opts.CompressKeys {
+				checkPacked(t, joined)
+			}
+			l.DeleteRange(nil, seqKey(end))
+			r.DeleteRange(nil, seqKey(end))
+			joined.ReplaceOrInsert(seqKey(end), nil)
+			checkSeq(t, joined, 0, end+1)
+			checkSeq(t, l, 0, 0)
+		}
+	}
+}
+
+func TestJoinOverlapping(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Join of overlapping trees didn't panic")
+		}
+	}()
+	Join(seqTree(3, 0, 10), seqTree(3, 9, 20))
+}
+
+func BenchmarkSplitJoin(b *testing.B) {
+	const size = 1_000_000
+	tr := New()
+	for i := 0; i < size; i++ {
+		tr.ReplaceOrInsert(seqKey(i), nil)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l, r := tr.SplitAt(seqKey(i % size))
+		tr = Join(l, r)
+	}
+}
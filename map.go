@@ -0,0 +1,386 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import "encoding/binary"
+
+// Map is a B-Tree keyed by []byte, like BTree, that stores values of type V
+// instead of []byte.
+//
+// A Map is a BTree, with its nodes, free list, clones and rules for
+// concurrent use, whose values refer to slots of a slab of V.  Keys are stored
+// as given and must not be modified while in the map.
+type Map[V any] struct {
+	t      *BTree
+	values *slab[V]
+}
+
+// MapIterator allows callers of Map.Ascend* and Map.Descend* to iterate
+// in-order over portions of a map.  When this function returns false,
+// iteration stops.
+type MapIterator[V any] func(k []byte, v V) bool
+
+// MapOptions configures a map created by NewMapWithOptions.  The zero value of
+// every field selects the default, as for Options.
+type MapOptions struct {
+	// Degree is the node degree of the map.  Zero means Degree.
+	Degree int
+	// FreeList is the node free list used by the map, which it can share with
+	// BTrees and other maps.  If nil, the map gets its own list of
+	// DefaultFreeListSize.
+	FreeList *FreeList
+	// Compare orders the keys of the map.  If nil, keys are ordered by
+	// bytes.Compare.
+	Compare CompareFunc
+}
+
+// NewMap creates a new map of the default Degree.
+func NewMap[V any]() *Map[V] {
+	return NewMapWithOptions[V](MapOptions{})
+}
+
+// NewMapWithOptions creates a new map configured by opts.
+//
+// It panics if opts.Degree is less than 2 (and not zero).
+func NewMapWithOptions[V any](opts MapOptions) *Map[V] {
+	return &Map[V]{t: newMapTree(opts), values: &slab[V]{}}
+}
+
+// newMapTree returns the tree behind a map or set configured by opts.
+func newMapTree(opts MapOptions) *BTree {
+	return NewWithOptions(Options{Degree: opts.Degree, FreeList: opts.FreeList, Compare: opts.Compare})
+}
+
+// slabChunk is the number of values in a chunk of a slab.
+const slabChunk = 128
+
+// slab holds the values of a Map.  The tree of the map stores, as the value
+// of each key, a reference to the slot holding its value: the slot number in
+// 8 big-endian bytes, cut from a chunk of references made along with each
+// chunk of values, so that neither costs an allocation per item.
+//
+// Like nodes, slots are shared with clones, and never written once shared: a
+// map and its clone both stop allocating from the slots they had, and a value
+// replaced or removed from a shared slot only leaves it dead.  The slots
+// allocated since are the map's own, and are overwritten or reused freely.
+// Once dead slots outnumber live ones, the map moves its values to a new
+// slab, letting the dead values be collected.
+type slab[V any] struct {
+	values [][]V
+	refs   [][]byte
+	next   int   // first slot never used
+	shared int   // slots below shared may be referenced by clones
+	free   []int // unused slots below next, not shared
+	dead   int   // shared slots no longer referenced
+}
+
+// slot returns the slot ref refers to.
+func slot(ref []byte) int {
+	return int(binary.BigEndian.Uint64(ref))
+}
+
+func (s *slab[V]) get(ref []byte) V {
+	i := slot(ref)
+	return s.values[i/slabChunk][i%slabChunk]
+}
+
+// put stores v in a free slot and returns a reference to it.
+func (s *slab[V]) put(v V) []byte {
+	var i int
+	if n := len(s.free); n > 0 {
+		i = s.free[n-1]
+		s.free = s.free[:n-1]
+	} else {
+		if s.next == len(s.values)*slabChunk {
+			s.grow()
+		}
+		i = s.next
+		s.next++
+	}
+	s.values[i/slabChunk][i%slabChunk] = v
+	j := 8 * (i % slabChunk)
+	return s.refs[i/slabChunk][j : j+8 : j+8]
+}
+
+// grow adds a chunk to s.
+func (s *slab[V]) grow() {
+	c := len(s.values)
+	refs := make([]byte, 8*slabChunk)
+	for j := 0; j < slabChunk; j++ {
+		binary.BigEndian.PutUint64(refs[8*j:], uint64(c*slabChunk+j))
+	}
+	s.values = append(s.values, make([]V, slabChunk))
+	s.refs = append(s.refs, refs)
+}
+
+// set stores v in the slot ref refers to, unless the slot is shared, and
+// reports whether it did.
+func (s *slab[V]) set(ref []byte, v V) bool {
+	i := slot(ref)
+	if i < s.shared {
+		return false
+	}
+	s.values[i/slabChunk][i%slabChunk] = v
+	return true
+}
+
+// release records that the slot ref refers to is no longer used.
+func (s *slab[V]) release(ref []byte) {
+	i := slot(ref)
+	if i < s.shared {
+		s.dead++
+		return
+	}
+	var zero V
+	s.values[i/slabChunk][i%slabChunk] = zero
+	s.free = append(s.free, i)
+}
+
+// fork returns a copy of s for a clone of its map.  Every slot in use is
+// shared from then on.  s keeps filling its last chunk, and the copy starts a
+// new one.
+func (s *slab[V]) fork() *slab[V] {
+	s.shared, s.free = s.next, nil
+	out := *s
+	n := len(s.values)
+	out.values, out.refs = s.values[:n:n], s.refs[:n:n]
+	out.next = n * slabChunk
+	return &out
+}
+
+// maybeCompact moves the values of m to a new slab if too many slots of the
+// current one are dead.
+func (m *Map[V]) maybeCompact() {
+	s := m.values
+	if s.dead <= m.t.length || s.dead < slabChunk {
+		return
+	}
+	out := &slab[V]{}
+	if m.t.root != nil {
+		m.t.root = m.t.root.mutableFor(m.t.cow)
+		m.t.root.moveValues(func(ref []byte) []byte { return out.put(s.get(ref)) })
+	}
+	m.values = out
+}
+
+// moveValues replaces every value v of the subtree by fn(v).
+func (n *node) moveValues(fn func(v []byte) []byte) {
+	for i := range n.items {
+		n.items[i][1] = fn(n.items[i][1])
+	}
+	for i := range n.children {
+		n.mutableChild(i).moveValues(fn)
+	}
+}
+
+// iterator returns the ItemIterator calling iterator with the values the
+// references it is called with refer to.
+func (m *Map[V]) iterator(iterator MapIterator[V]) ItemIterator {
+	return func(k, ref []byte) bool {
+		return iterator(k, m.values.get(ref))
+	}
+}
+
+// Clone clones the map, lazily, as BTree.Clone does.
+func (m *Map[V]) Clone() *Map[V] {
+	return &Map[V]{t: m.t.Clone(), values: m.values.fork()}
+}
+
+// ReplaceOrInsert sets the value of key k to v.  If k was already in the map,
+// its previous value is returned along with true.
+//
+// A nil key cannot be added to the map (will panic).
+func (m *Map[V]) ReplaceOrInsert(k []byte, v V) (old V, replaced bool) {
+	if k == nil {
+		panic("nil item being added to Map")
+	}
+	m.t.upsert(k, func(ref []byte, exists bool) ([]byte, upsertAction) {
+		if !exists {
+			return m.values.put(v), upsertPut
+		}
+		old, replaced = m.values.get(ref), true
+		if m.values.set(ref, v) {
+			return nil, upsertNone
+		}
+		m.values.release(ref)
+		return m.values.put(v), upsertPut
+	})
+	m.maybeCompact()
+	return old, replaced
+}
+
+// Get returns the value of key, and whether key is in the map.
+func (m *Map[V]) Get(key []byte) (V, bool) {
+	if ref, ok := m.t.Get(key); ok {
+		return m.values.get(ref), true
+	}
+	var zero V
+	return zero, false
+}
+
+// Has returns true if the given key is in the map.
+func (m *Map[V]) Has(key []byte) bool {
+	return m.t.Has(key)
+}
+
+// Delete removes key from the map, returning its value and whether it was
+// there.
+func (m *Map[V]) Delete(key []byte) (V, bool) {
+	k, v := m.remove(m.t.Delete(key))
+	return v, k != nil
+}
+
+// DeleteMin removes the smallest key in the map and returns it with its
+// value.  It returns a nil key if the map is empty.
+func (m *Map[V]) DeleteMin() ([]byte, V) {
+	return m.remove(m.t.DeleteMin())
+}
+
+// DeleteMax removes the largest key in the map and returns it with its value.
+// It returns a nil key if the map is empty.
+func (m *Map[V]) DeleteMax() ([]byte, V) {
+	return m.remove(m.t.DeleteMax())
+}
+
+// DeleteAt removes the item at index i of the map in ascending order, as
+// BTree.DeleteAt does, and returns its key and value.  It returns a nil key
+// if i is out of range.
+func (m *Map[V]) DeleteAt(i int) ([]byte, V) {
+	return m.remove(m.t.DeleteAt(i))
+}
+
+// remove returns the key and value of an item removed from the tree of m, if
+// k isn't nil, and releases its slot.
+func (m *Map[V]) remove(k, ref []byte) ([]byte, V) {
+	var v V
+	if k != nil {
+		v = m.values.get(ref)
+		m.values.release(ref)
+		m.maybeCompact()
+	}
+	return k, v
+}
+
+// DeleteRange removes every key within the range [greaterOrEqual, lessThan),
+// as BTree.DeleteRange does, and returns the number of keys removed.
+func (m *Map[V]) DeleteRange(greaterOrEqual, lessThan []byte) int {
+	// BTree.DeleteRange drops whole subtrees without visiting them, so the
+	// slots are released beforehand.
+	m.t.AscendRange(greaterOrEqual, lessThan, func(_, ref []byte) bool {
+		m.values.release(ref)
+		return true
+	})
+	n := m.t.DeleteRange(greaterOrEqual, lessThan)
+	m.maybeCompact()
+	return n
+}
+
+// Min returns the smallest key in the map and its value.  It returns a nil key
+// if the map is empty.
+func (m *Map[V]) Min() ([]byte, V) {
+	return m.item(m.t.Min())
+}
+
+// Max returns the largest key in the map and its value.  It returns a nil key
+// if the map is empty.
+func (m *Map[V]) Max() ([]byte, V) {
+	return m.item(m.t.Max())
+}
+
+// GetAt returns the key and value at index i of the map in ascending order.
+// It returns a nil key if i is out of range.
+func (m *Map[V]) GetAt(i int) ([]byte, V) {
+	return m.item(m.t.GetAt(i))
+}
+
+// item returns k with the value ref refers to, if k isn't nil.
+func (m *Map[V]) item(k, ref []byte) ([]byte, V) {
+	var v V
+	if k != nil {
+		v = m.values.get(ref)
+	}
+	return k, v
+}
+
+// Rank returns the number of keys in the map less than key, as BTree.Rank
+// does.
+func (m *Map[V]) Rank(key []byte) int {
+	return m.t.Rank(key)
+}
+
+// CountRange returns the number of keys in the map within the range
+// [greaterOrEqual, lessThan).
+func (m *Map[V]) CountRange(greaterOrEqual, lessThan []byte) int {
+	return m.t.CountRange(greaterOrEqual, lessThan)
+}
+
+// Len returns the number of keys currently in the map.
+func (m *Map[V]) Len() int {
+	return m.t.Len()
+}
+
+// Clear removes all keys from the map, as BTree.Clear does.
+func (m *Map[V]) Clear(addNodesToFreelist bool) {
+	m.t.Clear(addNodesToFreelist)
+	m.values = &slab[V]{}
+}
+
+// AscendRange calls the iterator for every key in the map within the range
+// [greaterOrEqual, lessThan), until iterator returns false.
+func (m *Map[V]) AscendRange(greaterOrEqual, lessThan []byte, iterator MapIterator[V]) {
+	m.t.AscendRange(greaterOrEqual, lessThan, m.iterator(iterator))
+}
+
+// AscendLessThan calls the iterator for every key in the map within the range
+// [first, pivot), until iterator returns false.
+func (m *Map[V]) AscendLessThan(pivot []byte, iterator MapIterator[V]) {
+	m.t.AscendLessThan(pivot, m.iterator(iterator))
+}
+
+// AscendGreaterOrEqual calls the iterator for every key in the map within the
+// range [pivot, last], until iterator returns false.
+func (m *Map[V]) AscendGreaterOrEqual(pivot []byte, iterator MapIterator[V]) {
+	m.t.AscendGreaterOrEqual(pivot, m.iterator(iterator))
+}
+
+// Ascend calls the iterator for every key in the map within the range
+// [first, last], until iterator returns false.
+func (m *Map[V]) Ascend(iterator MapIterator[V]) {
+	m.t.Ascend(m.iterator(iterator))
+}
+
+// DescendRange calls the iterator for every key in the map within the range
+// [lessOrEqual, greaterThan), until iterator returns false.
+func (m *Map[V]) DescendRange(lessOrEqual, greaterThan []byte, iterator MapIterator[V]) {
+	m.t.DescendRange(lessOrEqual, greaterThan, m.iterator(iterator))
+}
+
+// DescendLessOrEqual calls the iterator for every key in the map within the
+// range [pivot, first], until iterator returns false.
+func (m *Map[V]) DescendLessOrEqual(pivot []byte, iterator MapIterator[V]) {
+	m.t.DescendLessOrEqual(pivot, m.iterator(iterator))
+}
+
+// DescendGreaterThan calls the iterator for every key in the map within the
+// range [last, pivot), until iterator returns false.
+func (m *Map[V]) DescendGreaterThan(pivot []byte, iterator MapIterator[V]) {
+	m.t.DescendGreaterThan(pivot, m.iterator(iterator))
+}
+
+// Descend calls the iterator for every key in the map within the range
+// [last, first], until iterator returns false.
+func (m *Map[V]) Descend(iterator MapIterator[V]) {
+	m.t.Descend(m.iterator(iterator))
+}
@@ -0,0 +1,418 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// checkMap verifies the structural invariants of m, and that its keys refer
+// to distinct slots in use.
+func checkMap[V any](t *testing.T, m *Map[V]) {
+	t.Helper()
+	checkTree(t, m.t)
+	free := make(map[int]bool)
+	for _, i := range m.values.free {
+		free[i] = true
+	}
+	seen := make(map[int]bool)
+	m.t.Ascend(func(k, ref []byte) bool {
+		i := slot(ref)
+		if seen[i] || free[i] || i >= m.values.next {
+			t.Fatalf("key %x refers to slot %d: seen %v, free %v, next %d", k, i, seen[i], free[i], m.values.next)
+		}
+		seen[i] = true
+		return true
+	})
+}
+
+// mapKeys returns the keys and values visited by an iteration.
+func mapKeys(iterate func(MapIterator[int])) (keys [][]byte, values []int) {
+	iterate(func(k []byte, v int) bool {
+		keys = append(keys, k)
+		values = append(values, v)
+		return true
+	})
+	return keys, values
+}
+
+func TestMap(t *testing.T) {
+	const size = 1000
+	for _, degree := range []int{2, 3, 8} {
+		m := NewMapWithOptions[int](MapOptions{Degree: degree})
+		keys := make([][]byte, size)
+		for i := range keys {
+			keys[i] = seqKey(i)
+		}
+		for _, i := range rand.Perm(size) {
+			if _, ok := m.ReplaceOrInsert(keys[i], i); ok {
+				t.Fatalf("degree %d: insert %d reported a replace", degree, i)
+			}
+		}
+		checkMap(t, m)
+		if m.Len() != size {
+			t.Fatalf("degree %d: len %d, want %d", degree, m.Len(), size)
+		}
+		for _, i := range rand.Perm(size) {
+			if old, ok := m.ReplaceOrInsert(keys[i], -i); !ok || old != i {
+				t.Fatalf("degree %d: replace %d: got %d, %v", degree, i, old, ok)
+			}
+		}
+		for i := range keys {
+			if v, ok := m.Get(keys[i]); !ok || v != -i {
+				t.Fatalf("degree %d: get %d: got %d, %v", degree, i, v, ok)
+			}
+		}
+		if _, ok := m.Get([]byte("missing")); ok {
+			t.Fatalf("degree %d: found a missing key", degree)
+		}
+		if k, v := m.Min(); !reflect.DeepEqual(k, keys[0]) || v != 0 {
+			t.Fatalf("degree %d: min: got %x, %d", degree, k, v)
+		}
+		if k, v := m.Max(); !reflect.DeepEqual(k, keys[size-1]) || v != -(size-1) {
+			t.Fatalf("degree %d: max: got %x, %d", degree, k, v)
+		}
+		for _, i := range rand.Perm(size) {
+			if v, ok := m.Delete(keys[i]); !ok || v != -i {
+				t.Fatalf("degree %d: delete %d: got %d, %v", degree, i, v, ok)
+			}
+			if i%100 == 0 {
+				checkMap(t, m)
+			}
+		}
+		if m.Len() != 0 || m.t.root != nil && len(m.t.root.items) != 0 {
+			t.Fatalf("degree %d: map not empty after deleting everything", degree)
+		}
+		if k, _ := m.DeleteMin(); k != nil {
+			t.Fatalf("degree %d: DeleteMin on an empty map returned %x", degree, k)
+		}
+	}
+}
+
+func TestMapIterate(t *testing.T) {
+	const size = 300
+	m := NewMapWithOptions[int](MapOptions{Degree: 3})
+	keys := make([][]byte, size)
+	values := make([]int, size)
+	for i := range keys {
+		keys[i] = seqKey(2 * i)
+		values[i] = i
+		m.ReplaceOrInsert(keys[i], i)
+	}
+	// Bounds fall both on and between keys.
+	for lo := -1; lo <= 2*size+1; lo += 7 {
+		for hi := lo; hi <= 2*size+1; hi += 13 {
+			from, to := (lo+1)/2, (hi+1)/2
+			if from > size {
+				from = size
+			}
+			if to > size {
+				to = size
+			}
+			// A negative bound sorts before every key.
+			loKey, hiKey := []byte{}, []byte{}
+			if lo >= 0 {
+				loKey = seqKey(lo)
+			}
+			if hi >= 0 {
+				hiKey = seqKey(hi)
+			}
+			gotKeys, gotValues := mapKeys(func(it MapIterator[int]) { m.AscendRange(loKey, hiKey, it) })
+			if len(gotKeys) != to-from {
+				t.Fatalf("ascend [%d, %d): got %d keys, want %d", lo, hi, len(gotKeys), to-from)
+			}
+			if len(gotKeys) > 0 && (!reflect.DeepEqual(gotKeys, keys[from:to]) || !reflect.DeepEqual(gotValues, values[from:to])) {
+				t.Fatalf("ascend [%d, %d): values differ", lo, hi)
+			}
+
+			// Descending from hi down to (but excluding) lo.
+			dfrom, dto := (lo+2)/2, hi/2+1
+			if lo < 0 {
+				dfrom = 0
+			}
+			if hi < 0 {
+				dto = 0
+			}
+			if dto > size {
+				dto = size
+			}
+			var want [][]byte
+			for i := dto - 1; i >= dfrom; i-- {
+				want = append(want, keys[i])
+			}
+			gotKeys, _ = mapKeys(func(it MapIterator[int]) { m.DescendRange(hiKey, loKey, it) })
+			if !reflect.DeepEqual(gotKeys, want) {
+				t.Fatalf("descend [%d, %d): got %x, want %x", hi, lo, gotKeys, want)
+			}
+		}
+	}
+
+	got, _ := mapKeys(m.Ascend)
+	if !reflect.DeepEqual(got, keys) {
+		t.Fatalf("ascend: got %d keys, want %d", len(got), len(keys))
+	}
+	got, _ = mapKeys(m.Descend)
+	if len(got) != size || !reflect.DeepEqual(got[0], keys[size-1]) || !reflect.DeepEqual(got[size-1], keys[0]) {
+		t.Fatalf("descend: got %d keys", len(got))
+	}
+	got, _ = mapKeys(func(it MapIterator[int]) { m.AscendLessThan(keys[10], it) })
+	if !reflect.DeepEqual(got, keys[:10]) {
+		t.Fatalf("ascend less than: got %x", got)
+	}
+	got, _ = mapKeys(func(it MapIterator[int]) { m.AscendGreaterOrEqual(keys[size-10], it) })
+	if !reflect.DeepEqual(got, keys[size-10:]) {
+		t.Fatalf("ascend greater or equal: got %x", got)
+	}
+	got, _ = mapKeys(func(it MapIterator[int]) { m.DescendLessOrEqual(keys[2], it) })
+	if !reflect.DeepEqual(got, [][]byte{keys[2], keys[1], keys[0]}) {
+		t.Fatalf("descend less or equal: got %x", got)
+	}
+	got, _ = mapKeys(func(it MapIterator[int]) { m.DescendGreaterThan(keys[size-3], it) })
+	if !reflect.DeepEqual(got, [][]byte{keys[size-1], keys[size-2]}) {
+		t.Fatalf("descend greater than: got %x", got)
+	}
+
+	n := 0
+	m.Ascend(func([]byte, int) bool { n++; return n < 5 })
+	if n != 5 {
+		t.Fatalf("ascend did not stop: %d calls", n)
+	}
+}
+
+func TestMapClone(t *testing.T) {
+	const size = 500
+	m := NewMapWithOptions[string](MapOptions{Degree: 2})
+	for i := 0; i < size; i++ {
+		m.ReplaceOrInsert(seqKey(i), "a")
+	}
+	c := m.Clone()
+	for i := 0; i < size; i += 2 {
+		m.Delete(seqKey(i))
+		c.ReplaceOrInsert(seqKey(i+1), "b")
+	}
+	checkMap(t, m)
+	checkMap(t, c)
+	if m.Len() != size/2 || c.Len() != size {
+		t.Fatalf("lens: got %d and %d", m.Len(), c.Len())
+	}
+	for i := 0; i < size; i++ {
+		v, ok := m.Get(seqKey(i))
+		if ok != (i%2 == 1) || (ok && v != "a") {
+			t.Fatalf("original key %d: got %q, %v", i, v, ok)
+		}
+		want := "a"
+		if i%2 == 1 {
+			want = "b"
+		}
+		if v, _ := c.Get(seqKey(i)); v != want {
+			t.Fatalf("clone key %d: got %q, want %q", i, v, want)
+		}
+	}
+}
+
+func TestMapCompare(t *testing.T) {
+	m := NewMapWithOptions[int](MapOptions{Degree: 2, Compare: reverseCompare})
+	for i := 0; i < 100; i++ {
+		m.ReplaceOrInsert(seqKey(i), i)
+	}
+	checkMap(t, m)
+	_, values := mapKeys(m.Ascend)
+	for i, v := range values {
+		if v != 99-i {
+			t.Fatalf("ascend position %d: got %d, want %d", i, v, 99-i)
+		}
+	}
+}
+
+func TestMapSlab(t *testing.T) {
+	const size = 1000
+	m := NewMapWithOptions[*int](MapOptions{Degree: 3})
+	for i := 0; i < size; i++ {
+		v := i
+		m.ReplaceOrInsert(seqKey(i), &v)
+	}
+	// Values the map doesn't share with a clone are overwritten in place,
+	// and the slots of removed ones reused.
+	next := m.values.next
+	for i := 0; i < size; i++ {
+		v := -i
+		m.ReplaceOrInsert(seqKey(i), &v)
+	}
+	ref, _ := m.t.Get(seqKey(0))
+	m.Delete(seqKey(0))
+	if p := m.values.get(ref); p != nil {
+		t.Fatalf("removed value still held: %d", *p)
+	}
+	v := 0
+	m.ReplaceOrInsert(seqKey(0), &v)
+	if m.values.next != next {
+		t.Fatalf("slab grew from %d to %d slots without a clone", next, m.values.next)
+	}
+	checkMap(t, m)
+
+	// Once shared, values are left for the clone, until the map moves its
+	// own elsewhere.
+	c := m.Clone()
+	var wg sync.WaitGroup
+	for _, w := range []*Map[*int]{m, c} {
+		wg.Add(1)
+		go func(w *Map[*int]) {
+			defer wg.Done()
+			for i := 0; i < size; i++ {
+				v := i + size
+				w.ReplaceOrInsert(seqKey(i), &v)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if m.values.dead != size {
+		t.Fatalf("%d dead slots after replacing every shared value, want %d", m.values.dead, size)
+	}
+	m.DeleteRange(seqKey(size-10), seqKey(size))
+	c.DeleteRange(seqKey(0), seqKey(size/2))
+	checkMap(t, m)
+	checkMap(t, c)
+	if m.values.dead != 0 || c.values.dead != 0 {
+		t.Fatalf("dead slots left after compaction: %d and %d", m.values.dead, c.values.dead)
+	}
+	for i := 0; i < size; i++ {
+		if v, ok := m.Get(seqKey(i)); ok != (i < size-10) || ok && *v != i+size {
+			t.Fatalf("map key %d: got %v, %v", i, v, ok)
+		}
+		if v, ok := c.Get(seqKey(i)); ok != (i >= size/2) || ok && *v != i+size {
+			t.Fatalf("clone key %d: got %v, %v", i, v, ok)
+		}
+	}
+}
+
+func BenchmarkMapInsert(b *testing.B) {
+	keys := make([][]byte, 100_000)
+	for i, j := range rand.Perm(len(keys)) {
+		keys[i] = seqKey(j)
+	}
+	b.Run("Map", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m := NewMap[uint64]()
+			for j, k := range keys {
+				m.ReplaceOrInsert(k, uint64(j))
+			}
+		}
+	})
+	b.Run("BTree", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tr := New()
+			for _, k := range keys {
+				tr.ReplaceOrInsert(k, k)
+			}
+		}
+	})
+}
+
+// Map must behave as a BTree holding the same items, nil bounds included.
+func TestMapMatchesBTree(t *testing.T) {
+	m := NewMapWithOptions[[]byte](MapOptions{Degree: 3})
+	tr := NewWithOptions(Options{Degree: 3})
+	key := func() []byte {
+		if rand.Intn(50) == 0 {
+			return []byte{}
+		}
+		return seqKey(rand.Intn(500))
+	}
+	bounds := func() []byte {
+		switch rand.Intn(4) {
+		case 0:
+			return nil
+		case 1:
+			return []byte{}
+		}
+		return key()
+	}
+	type scan struct {
+		name  string
+		btree func(a, b []byte, it ItemIterator)
+		m     func(a, b []byte, it MapIterator[[]byte])
+	}
+	scans := []scan{
+		{"AscendRange", tr.AscendRange, m.AscendRange},
+		{"DescendRange", tr.DescendRange, m.DescendRange},
+		{"AscendLessThan", func(a, _ []byte, it ItemIterator) { tr.AscendLessThan(a, it) }, func(a, _ []byte, it MapIterator[[]byte]) { m.AscendLessThan(a, it) }},
+		{"AscendGreaterOrEqual", func(a, _ []byte, it ItemIterator) { tr.AscendGreaterOrEqual(a, it) }, func(a, _ []byte, it MapIterator[[]byte]) { m.AscendGreaterOrEqual(a, it) }},
+		{"DescendLessOrEqual", func(a, _ []byte, it ItemIterator) { tr.DescendLessOrEqual(a, it) }, func(a, _ []byte, it MapIterator[[]byte]) { m.DescendLessOrEqual(a, it) }},
+		{"DescendGreaterThan", func(a, _ []byte, it ItemIterator) { tr.DescendGreaterThan(a, it) }, func(a, _ []byte, it MapIterator[[]byte]) { m.DescendGreaterThan(a, it) }},
+		{"Ascend", func(_, _ []byte, it ItemIterator) { tr.Ascend(it) }, func(_, _ []byte, it MapIterator[[]byte]) { m.Ascend(it) }},
+		{"Descend", func(_, _ []byte, it ItemIterator) { tr.Descend(it) }, func(_, _ []byte, it MapIterator[[]byte]) { m.Descend(it) }},
+	}
+	for i := 0; i < 3000; i++ {
+		k, v := key(), seqKey(i)
+		switch rand.Intn(8) {
+		case 0, 1, 2, 3:
+			_, old := tr.ReplaceOrInsert(k, v)
+			mold, ok := m.ReplaceOrInsert(k, v)
+			if !reflect.DeepEqual(mold, old) || ok != (old != nil) {
+				t.Fatalf("ReplaceOrInsert(%x) = %x, %v, want %x", k, mold, ok, old)
+			}
+		case 4, 5:
+			_, old := tr.Delete(k)
+			mold, ok := m.Delete(k)
+			if !reflect.DeepEqual(mold, old) || ok != (old != nil) {
+				t.Fatalf("Delete(%x) = %x, %v, want %x", k, mold, ok, old)
+			}
+		case 6:
+			wk, wv := tr.DeleteMin()
+			if gk, gv := m.DeleteMin(); !reflect.DeepEqual(gk, wk) || !reflect.DeepEqual(gv, wv) {
+				t.Fatalf("DeleteMin = %x, %x, want %x, %x", gk, gv, wk, wv)
+			}
+		default:
+			wk, wv := tr.DeleteMax()
+			if gk, gv := m.DeleteMax(); !reflect.DeepEqual(gk, wk) || !reflect.DeepEqual(gv, wv) {
+				t.Fatalf("DeleteMax = %x, %x, want %x, %x", gk, gv, wk, wv)
+			}
+		}
+		if m.Len() != tr.Len() {
+			t.Fatalf("Len = %d, want %d", m.Len(), tr.Len())
+		}
+		if i%50 != 0 {
+			continue
+		}
+		checkMap(t, m)
+		for _, s := range scans {
+			a, b := bounds(), bounds()
+			var want, got []Item
+			s.btree(a, b, func(k, v []byte) bool { want = append(want, Item{k, v}); return true })
+			s.m(a, b, func(k, v []byte) bool { got = append(got, Item{k, v}); return true })
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s(%x, %x):\n got: %x\nwant: %x", s.name, a, b, got, want)
+			}
+		}
+		wk, wv := tr.Min()
+		if gk, gv := m.Min(); !reflect.DeepEqual(gk, wk) || !reflect.DeepEqual(gv, wv) {
+			t.Fatalf("Min = %x, want %x", gk, wk)
+		}
+		wk, wv = tr.Max()
+		if gk, gv := m.Max(); !reflect.DeepEqual(gk, wk) || !reflect.DeepEqual(gv, wv) {
+			t.Fatalf("Max = %x, want %x", gk, wk)
+		}
+		q := bounds()
+		wv, wok := tr.Get(q)
+		if gv, gok := m.Get(q); !reflect.DeepEqual(gv, wv) || gok != wok || m.Has(q) != wok {
+			t.Fatalf("Get(%x) = %x, %v, want %x, %v", q, gv, gok, wv, wok)
+		}
+	}
+}
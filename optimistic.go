@@ -0,0 +1,448 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrConflict is matched, with errors.Is, by the error OptimisticTxn.Commit
+// returns when a key range the transaction read or wrote was written to by
+// another commit since the transaction began.
+var ErrConflict = errors.New("bytebtree: conflicting write")
+
+// ConflictError is the error OptimisticTxn.Commit returns on a conflict.  It
+// holds the range, read or written by the transaction, that another commit
+// wrote to.
+type ConflictError struct {
+	// Lo and Hi bound the range.  A nil bound leaves the range unbounded on
+	// its side.
+	Lo, Hi []byte
+	// LoExcl and HiExcl report whether Lo and Hi are left out of the range.
+	LoExcl, HiExcl bool
+}
+
+func (e *ConflictError) Error() string {
+	r := keyRange{lo: e.Lo, hi: e.Hi, loExcl: e.LoExcl, hiExcl: e.HiExcl}
+	return fmt.Sprintf("%v: %v", ErrConflict, r)
+}
+
+// Is reports whether target is ErrConflict.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// OptimisticTxn is a write transaction on a BTree that only fails to commit
+// if another write actually got in its way.
+//
+// Like Txn, it works on a Clone of its tree.  Unlike Txn, which fails to
+// commit as soon as the tree was written to since it began, it records the
+// key ranges it reads and writes, and the writes it makes.  Commit replays
+// those writes on the tree, unless another optimistic transaction committed
+// since wrote to one of the recorded ranges.  Writes made to the tree by any
+// other means, including Txn.Commit, can't be told apart, and conflict with
+// everything.
+//
+// The ranges recorded are those asked for: a scan stopped early by its
+// iterator still records its whole range.
+//
+// An OptimisticTxn must end with Commit or Rollback, as its tree keeps track
+// of the writes committed since each running transaction began.
+type OptimisticTxn struct {
+	t      *BTree
+	parent *BTree
+	base   *node
+	since  uint64
+	reads  []keyRange
+	writes []keyRange
+	log    []writeOp
+}
+
+// keyRange is a range of keys.  A nil bound leaves the range unbounded on its
+// side.
+type keyRange struct {
+	lo, hi         []byte
+	loExcl, hiExcl bool
+}
+
+// everything is the range of all keys.
+var everything = keyRange{}
+
+// pointRange returns the range holding key alone.
+func pointRange(key []byte) keyRange {
+	k := keep(key)
+	return keyRange{lo: k, hi: k}
+}
+
+// keep returns a non-nil copy of b, to record as a range bound.
+func keep(b []byte) []byte {
+	return append(make([]byte, 0, len(b)), b...)
+}
+
+// below reports whether every key of r sorts before every key of s.
+func (r keyRange) below(s keyRange, compare CompareFunc) bool {
+	if r.hi == nil || s.lo == nil {
+		return false
+	}
+	c := compare(r.hi, s.lo)
+	return c < 0 || c == 0 && (r.hiExcl || s.loExcl)
+}
+
+// overlaps reports whether r and s may share a key.
+func (r keyRange) overlaps(s keyRange, compare CompareFunc) bool {
+	return !r.below(s, compare) && !s.below(r, compare)
+}
+
+func (r keyRange) String() string {
+	lo, hi := "[-inf", "+inf]"
+	if r.lo != nil {
+		lo = fmt.Sprintf("[%x", r.lo)
+		if r.loExcl {
+			lo = "(" + lo[1:]
+		}
+	}
+	if r.hi != nil {
+		hi = fmt.Sprintf("%x]", r.hi)
+		if r.hiExcl {
+			hi = hi[:len(hi)-1] + ")"
+		}
+	}
+	return lo + ", " + hi
+}
+
+type opKind uint8
+
+const (
+	opPut opKind = iota
+	opDelete
+	opDeleteRange
+	opDeletePrefix
+)
+
+// writeOp is a write made by an OptimisticTxn, to replay on its tree.
+type writeOp struct {
+	kind opKind
+	k, v []byte // v is the end of the range for opDeleteRange
+}
+
+// commit is the set of ranges written to by a commit.
+type commit struct {
+	version uint64
+	writes  []keyRange
+}
+
+// commitLog records the writes committed to a tree for as long as an
+// optimistic transaction that began before them is running.
+type commitLog struct {
+	mu      sync.Mutex
+	version uint64
+	// root is the root of the tree after the last write the log knows of.
+	// The log makes sure the tree doesn't own it, so that any other write
+	// replaces it.
+	root    *node
+	commits []commit
+	active  map[uint64]int // running transactions by version they began at
+}
+
+// commitLogMu guards the creation of commit logs.
+var commitLogMu sync.Mutex
+
+// commitLog returns the commit log of t, creating it if needed.
+func (t *BTree) commitLog() *commitLog {
+	commitLogMu.Lock()
+	defer commitLogMu.Unlock()
+	if t.commits == nil {
+		t.commits = &commitLog{root: t.root, active: make(map[uint64]int)}
+	}
+	return t.commits
+}
+
+// sync records the writes made to t outside of optimistic transactions since
+// the last one the log knows of.
+func (l *commitLog) sync(t *BTree) {
+	if t.root != l.root {
+		l.add([]keyRange{everything})
+		t.disown()
+		l.root = t.root
+	}
+}
+
+// add records a commit that wrote to writes.
+func (l *commitLog) add(writes []keyRange) {
+	l.version++
+	if len(l.active) > 0 {
+		l.commits = append(l.commits, commit{version: l.version, writes: writes})
+	}
+}
+
+// end records that a transaction begun at version since is over, and forgets
+// the commits no running transaction began before.
+func (l *commitLog) end(since uint64) {
+	if l.active[since]--; l.active[since] == 0 {
+		delete(l.active, since)
+	}
+	min := l.version
+	for v := range l.active {
+		if v < min {
+			min = v
+		}
+	}
+	i := 0
+	for i < len(l.commits) && l.commits[i].version <= min {
+		l.commits[i] = commit{}
+		i++
+	}
+	l.commits = l.commits[i:]
+}
+
+// BeginOptimistic starts an optimistic transaction on t.
+//
+// BeginOptimistic and OptimisticTxn.Commit may be called concurrently, for
+// transactions on the same tree, but not concurrently with other calls on t.
+func (t *BTree) BeginOptimistic() *OptimisticTxn {
+	l := t.commitLog()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sync(t)
+	l.active[l.version]++
+	return &OptimisticTxn{t: t.Clone(), parent: t, base: t.root, since: l.version}
+}
+
+// Commit replays the writes of the transaction on the tree it began on, and
+// ends the transaction.  It fails with a *ConflictError, leaving the tree
+// untouched and rolling the transaction back, if a range the transaction read
+// or wrote was written to since BeginOptimistic.
+func (txn *OptimisticTxn) Commit() error {
+	if txn.t == nil {
+		return ErrTxnDone
+	}
+	p := txn.parent
+	l := p.commits
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer l.end(txn.since)
+	l.sync(p)
+	compare := p.cow.compare
+	for _, c := range l.commits {
+		if c.version <= txn.since {
+			continue
+		}
+		for _, w := range c.writes {
+			for _, r := range [][]keyRange{txn.reads, txn.writes} {
+				for _, s := range r {
+					if w.overlaps(s, compare) {
+						txn.rollback()
+						return &ConflictError{Lo: s.lo, Hi: s.hi, LoExcl: s.loExcl, HiExcl: s.hiExcl}
+					}
+				}
+			}
+		}
+	}
+
+	if p.root == txn.base {
+		// Nothing was written since the transaction began: the tree can
+		// take its place, as Txn.Commit does.
+		p.root, p.length, p.cow = txn.t.root, txn.t.length, txn.t.cow
+	} else {
+		txn.replay()
+		txn.rollback()
+	}
+	p.disown()
+	if len(txn.writes) > 0 {
+		l.add(txn.writes)
+	}
+	l.root = p.root
+	txn.t = nil
+	return nil
+}
+
+// replay applies the writes of the transaction to its tree.
+func (txn *OptimisticTxn) replay() {
+	p := txn.parent
+	for _, op := range txn.log {
+		switch op.kind {
+		case opPut:
+			p.ReplaceOrInsert(op.k, op.v)
+		case opDelete:
+			p.Delete(op.k)
+		case opDeleteRange:
+			p.DeleteRange(op.k, op.v)
+		case opDeletePrefix:
+			p.DeletePrefix(op.k)
+		}
+	}
+}
+
+// Rollback discards the writes of the transaction and ends it.  Rollback does
+// nothing if the transaction is already over.
+func (txn *OptimisticTxn) Rollback() {
+	if txn.t == nil {
+		return
+	}
+	l := txn.parent.commits
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.end(txn.since)
+	txn.rollback()
+}
+
+// rollback returns the nodes the transaction created to the free list, and
+// ends it.
+func (txn *OptimisticTxn) rollback() {
+	if txn.t.root != nil {
+		txn.t.cow.freeOwned(txn.t.root)
+	}
+	txn.t = nil
+}
+
+func (txn *OptimisticTxn) read(r keyRange) {
+	txn.reads = append(txn.reads, r)
+}
+
+func (txn *OptimisticTxn) write(r keyRange, op writeOp) {
+	txn.writes = append(txn.writes, r)
+	txn.log = append(txn.log, op)
+}
+
+// ReplaceOrInsert is BTree.ReplaceOrInsert, writing to key.
+func (txn *OptimisticTxn) ReplaceOrInsert(k, v []byte) ([]byte, []byte) {
+	oldK, oldV := txn.t.ReplaceOrInsert(k, v)
+	r := pointRange(k)
+	var cv []byte
+	if v != nil {
+		cv = keep(v)
+	}
+	txn.write(r, writeOp{kind: opPut, k: r.lo, v: cv})
+	return oldK, oldV
+}
+
+// Delete is BTree.Delete, writing to key.
+func (txn *OptimisticTxn) Delete(k []byte) ([]byte, []byte) {
+	r := pointRange(k)
+	txn.write(r, writeOp{kind: opDelete, k: r.lo})
+	return txn.t.Delete(k)
+}
+
+// DeleteRange is BTree.DeleteRange, writing to the range.
+func (txn *OptimisticTxn) DeleteRange(greaterOrEqual, lessThan []byte) int {
+	r := keyRange{lo: keep(greaterOrEqual), hi: keep(lessThan), hiExcl: true}
+	txn.write(r, writeOp{kind: opDeleteRange, k: r.lo, v: r.hi})
+	return txn.t.DeleteRange(greaterOrEqual, lessThan)
+}
+
+// DeletePrefix is BTree.DeletePrefix, writing to the keys starting with
+// prefix.
+func (txn *OptimisticTxn) DeletePrefix(prefix []byte) int {
+	n := txn.t.DeletePrefix(prefix)
+	r := prefixRange(prefix)
+	txn.write(r, writeOp{kind: opDeletePrefix, k: r.lo})
+	return n
+}
+
+// prefixRange returns the range of the keys starting with prefix.
+func prefixRange(prefix []byte) keyRange {
+	from, to := prefixBounds(keep(prefix))
+	r := keyRange{lo: from[0], hiExcl: true}
+	if to != nil {
+		r.hi = to[0]
+	}
+	return r
+}
+
+// Get is BTree.Get, reading key.
+func (txn *OptimisticTxn) Get(key []byte) ([]byte, bool) {
+	txn.read(pointRange(key))
+	return txn.t.Get(key)
+}
+
+// Has is BTree.Has, reading key.
+func (txn *OptimisticTxn) Has(key []byte) bool {
+	txn.read(pointRange(key))
+	return txn.t.Has(key)
+}
+
+// Len is BTree.Len, reading every key.
+func (txn *OptimisticTxn) Len() int {
+	txn.read(everything)
+	return txn.t.Len()
+}
+
+// CountRange is BTree.CountRange, reading the range.
+func (txn *OptimisticTxn) CountRange(greaterOrEqual, lessThan []byte) int {
+	txn.read(keyRange{lo: keep(greaterOrEqual), hi: keep(lessThan), hiExcl: true})
+	return txn.t.CountRange(greaterOrEqual, lessThan)
+}
+
+// Ascend is BTree.Ascend, reading every key.
+func (txn *OptimisticTxn) Ascend(iterator ItemIterator) {
+	txn.read(everything)
+	txn.t.Ascend(iterator)
+}
+
+// AscendRange is BTree.AscendRange, reading the range.
+func (txn *OptimisticTxn) AscendRange(greaterOrEqual, lessThan []byte, iterator ItemIterator) {
+	txn.read(keyRange{lo: keep(greaterOrEqual), hi: keep(lessThan), hiExcl: true})
+	txn.t.AscendRange(greaterOrEqual, lessThan, iterator)
+}
+
+// AscendLessThan is BTree.AscendLessThan, reading the range.
+func (txn *OptimisticTxn) AscendLessThan(pivot []byte, iterator ItemIterator) {
+	txn.read(keyRange{hi: keep(pivot), hiExcl: true})
+	txn.t.AscendLessThan(pivot, iterator)
+}
+
+// AscendGreaterOrEqual is BTree.AscendGreaterOrEqual, reading the range.
+func (txn *OptimisticTxn) AscendGreaterOrEqual(pivot []byte, iterator ItemIterator) {
+	txn.read(keyRange{lo: keep(pivot)})
+	txn.t.AscendGreaterOrEqual(pivot, iterator)
+}
+
+// AscendPrefix is BTree.AscendPrefix, reading the keys starting with prefix.
+func (txn *OptimisticTxn) AscendPrefix(prefix []byte, iterator ItemIterator) {
+	txn.read(prefixRange(prefix))
+	txn.t.AscendPrefix(prefix, iterator)
+}
+
+// Descend is BTree.Descend, reading every key.
+func (txn *OptimisticTxn) Descend(iterator ItemIterator) {
+	txn.read(everything)
+	txn.t.Descend(iterator)
+}
+
+// DescendRange is BTree.DescendRange, reading the range.
+func (txn *OptimisticTxn) DescendRange(lessOrEqual, greaterThan []byte, iterator ItemIterator) {
+	txn.read(keyRange{lo: keep(greaterThan), hi: keep(lessOrEqual), loExcl: true})
+	txn.t.DescendRange(lessOrEqual, greaterThan, iterator)
+}
+
+// DescendLessOrEqual is BTree.DescendLessOrEqual, reading the range.
+func (txn *OptimisticTxn) DescendLessOrEqual(pivot []byte, iterator ItemIterator) {
+	txn.read(keyRange{hi: keep(pivot)})
+	txn.t.DescendLessOrEqual(pivot, iterator)
+}
+
+// DescendGreaterThan is BTree.DescendGreaterThan, reading the range.
+func (txn *OptimisticTxn) DescendGreaterThan(pivot []byte, iterator ItemIterator) {
+	txn.read(keyRange{lo: keep(pivot), loExcl: true})
+	txn.t.DescendGreaterThan(pivot, iterator)
+}
+
+// DescendPrefix is BTree.DescendPrefix, reading the keys starting with prefix.
+func (txn *OptimisticTxn) DescendPrefix(prefix []byte, iterator ItemIterator) {
+	txn.read(prefixRange(prefix))
+	txn.t.DescendPrefix(prefix, iterator)
+}
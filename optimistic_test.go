@@ -0,0 +1,186 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestOptimisticTxn(t *testing.T) {
+	tr := seqTree(3, 0, 100)
+	txn1, txn2, txn3 := tr.BeginOptimistic(), tr.BeginOptimistic(), tr.BeginOptimistic()
+	txn1.DeleteRange(seqKey(0), seqKey(10))
+	for i := 100; i < 110; i++ {
+		txn2.ReplaceOrInsert(seqKey(i), nil)
+	}
+	if _, ok := txn3.Get(seqKey(5)); !ok {
+		t.Fatalf("Get: key 5 missing")
+	}
+	txn3.ReplaceOrInsert(seqKey(200), nil)
+
+	// txn1 and txn2 don't overlap, so both commit.
+	if err := txn1.Commit(); err != nil {
+		t.Fatalf("first Commit: %v", err)
+	}
+	if err := txn2.Commit(); err != nil {
+		t.Fatalf("second Commit: %v", err)
+	}
+	checkSeq(t, tr, 10, 110)
+	if err := txn2.Commit(); !errors.Is(err, ErrTxnDone) {
+		t.Fatalf("Commit again: got %v, want %v", err, ErrTxnDone)
+	}
+
+	// txn3 read a key txn1 deleted.
+	if err := txn3.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("conflicting Commit: got %v, want %v", err, ErrConflict)
+	}
+	checkSeq(t, tr, 10, 110)
+	if n := len(tr.commits.commits); n != 0 {
+		t.Fatalf("log holds %d commits with no transaction running", n)
+	}
+}
+
+func TestOptimisticTxnRanges(t *testing.T) {
+	// Each transaction reads or writes around key 49, which another one
+	// deletes.
+	each := func(k, v []byte) bool { return true }
+	for _, tc := range []struct {
+		name     string
+		read     func(txn *OptimisticTxn)
+		conflict bool
+	}{
+		{"AscendRange", func(txn *OptimisticTxn) { txn.AscendRange(seqKey(40), seqKey(50), each) }, true},
+		{"AscendRangeBefore", func(txn *OptimisticTxn) { txn.AscendRange(seqKey(40), seqKey(49), each) }, false},
+		{"AscendLessThan", func(txn *OptimisticTxn) { txn.AscendLessThan(seqKey(49), each) }, false},
+		{"AscendGreaterOrEqual", func(txn *OptimisticTxn) { txn.AscendGreaterOrEqual(seqKey(49), each) }, true},
+		{"DescendRange", func(txn *OptimisticTxn) { txn.DescendRange(seqKey(60), seqKey(49), each) }, false},
+		{"DescendLessOrEqual", func(txn *OptimisticTxn) { txn.DescendLessOrEqual(seqKey(49), each) }, true},
+		{"DescendGreaterThan", func(txn *OptimisticTxn) { txn.DescendGreaterThan(seqKey(49), each) }, false},
+		{"CountRange", func(txn *OptimisticTxn) { txn.CountRange(seqKey(49), seqKey(51)) }, true},
+		{"Len", func(txn *OptimisticTxn) { txn.Len() }, true},
+		{"Has", func(txn *OptimisticTxn) { txn.Has(seqKey(51)) }, false},
+		{"Delete", func(txn *OptimisticTxn) { txn.Delete(seqKey(49)) }, true},
+		{"DeletePrefix", func(txn *OptimisticTxn) { txn.DeletePrefix(seqKey(49)) }, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := seqTree(3, 0, 100)
+			txn := tr.BeginOptimistic()
+			tc.read(txn)
+			txn.ReplaceOrInsert(seqKey(100), nil)
+			other := tr.BeginOptimistic()
+			other.Delete(seqKey(49))
+			if err := other.Commit(); err != nil {
+				t.Fatalf("Commit: %v", err)
+			}
+			err := txn.Commit()
+			if got := errors.Is(err, ErrConflict); got != tc.conflict {
+				t.Fatalf("Commit: got %v, want conflict %v", err, tc.conflict)
+			}
+			if err == nil && !tr.Has(seqKey(100)) {
+				t.Fatalf("key written by the transaction missing")
+			}
+			if tr.Has(seqKey(49)) {
+				t.Fatalf("key deleted by the first commit is back")
+			}
+		})
+	}
+}
+
+func TestOptimisticTxnConflictError(t *testing.T) {
+	tr := seqTree(3, 0, 100)
+	txn := tr.BeginOptimistic()
+	txn.AscendRange(seqKey(40), seqKey(50), func(k, v []byte) bool { return true })
+	txn.ReplaceOrInsert(seqKey(100), nil)
+	other := tr.BeginOptimistic()
+	other.Delete(seqKey(49))
+	if err := other.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	err := txn.Commit()
+	var ce *ConflictError
+	if !errors.As(err, &ce) {
+		t.Fatalf("Commit: got %v, want a *ConflictError", err)
+	}
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("Commit: %v doesn't match %v", err, ErrConflict)
+	}
+	if !bytes.Equal(ce.Lo, seqKey(40)) || !bytes.Equal(ce.Hi, seqKey(50)) || ce.LoExcl || !ce.HiExcl {
+		t.Fatalf("conflicting range: got %v, want [%x, %x)", ce, seqKey(40), seqKey(50))
+	}
+	if errors.Is(err, ErrTxnDone) {
+		t.Fatalf("Commit: %v matches %v", err, ErrTxnDone)
+	}
+}
+
+func TestOptimisticTxnDirectWrite(t *testing.T) {
+	tr := seqTree(3, 0, 100)
+	txn := tr.BeginOptimistic()
+	txn.ReplaceOrInsert(seqKey(100), nil)
+	// A write to the tree itself can't be told apart from one to the range
+	// written by the transaction.
+	tr.Delete(seqKey(0))
+	if err := txn.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Commit: got %v, want %v", err, ErrConflict)
+	}
+
+	txn = tr.BeginOptimistic()
+	other := tr.BeginOptimistic()
+	other.ReplaceOrInsert(seqKey(100), nil)
+	if err := other.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	// The tree took over the nodes other wrote, but must still replace its
+	// root when written to directly.
+	tr.ReplaceOrInsert(seqKey(101), nil)
+	txn.ReplaceOrInsert(seqKey(102), nil)
+	if err := txn.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Commit after a direct write: got %v, want %v", err, ErrConflict)
+	}
+	checkSeq(t, tr, 1, 102)
+
+	txn = tr.BeginOptimistic()
+	txn.Delete(seqKey(1))
+	txn.Rollback()
+	checkSeq(t, tr, 1, 102)
+	if err := txn.Commit(); !errors.Is(err, ErrTxnDone) {
+		t.Fatalf("Commit after Rollback: got %v, want %v", err, ErrTxnDone)
+	}
+}
+
+func TestOptimisticTxnConcurrent(t *testing.T) {
+	const workers, perWorker = 8, 50
+	tr := NewWithOptions(Options{Degree: 3, CopyItems: true})
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				// Each worker writes keys of its own, so no commit conflicts.
+				txn := tr.BeginOptimistic()
+				txn.ReplaceOrInsert(seqKey(w*perWorker+i), nil)
+				if err := txn.Commit(); err != nil {
+					t.Errorf("Commit: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	checkSeq(t, tr, 0, workers*perWorker)
+}
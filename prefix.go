@@ -0,0 +1,109 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+// The prefix operations below rely on every key that starts with a prefix
+// sorting in one contiguous run right after the prefix itself, which holds for
+// the default bytes.Compare ordering but not necessarily for a custom
+// CompareFunc.  Rather than return wrong results, they panic on a tree with
+// Options.Compare.
+
+// checkPrefixOrder panics if t has a custom key order.
+func (t *BTree) checkPrefixOrder() {
+	if t.cow.customCompare {
+		panic("bytebtree: prefix operation on a tree with a custom Compare")
+	}
+}
+
+// prefixEnd returns the smallest key that sorts after every key starting with
+// prefix, or nil if there is no such key because prefix is empty or made of
+// 0xff bytes only.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// prefixBounds returns the range [from, to) holding the keys that start with
+// prefix.  to is nil when the range is unbounded above.
+func prefixBounds(prefix []byte) (from, to *Item) {
+	from = &Item{prefix}
+	if end := prefixEnd(prefix); end != nil {
+		to = &Item{end}
+	}
+	return from, to
+}
+
+// AscendPrefix calls the iterator for every value in the tree whose key
+// starts with prefix, in ascending order, until iterator returns false.  It
+// panics if the tree was created with Options.Compare.
+func (t *BTree) AscendPrefix(prefix []byte, iterator ItemIterator) {
+	t.checkPrefixOrder()
+	if t.root == nil {
+		return
+	}
+	from, to := prefixBounds(prefix)
+	t.root.iterate(ascend, from, to, true, false, iterator)
+}
+
+// DescendPrefix calls the iterator for every value in the tree whose key
+// starts with prefix, in descending order, until iterator returns false.  It
+// panics if the tree was created with Options.Compare.
+func (t *BTree) DescendPrefix(prefix []byte, iterator ItemIterator) {
+	t.checkPrefixOrder()
+	if t.root == nil {
+		return
+	}
+	// Descend from just below the end of the range, and stop at the first
+	// key outside of it: the start of a descent is exclusive, but its stop
+	// would leave out a key equal to prefix.
+	from, to := prefixBounds(prefix)
+	compare := t.cow.compare
+	t.root.iterate(descend, to, nil, false, false, func(k, v []byte) bool {
+		if compare(k, from[0]) < 0 {
+			return false
+		}
+		return iterator(k, v)
+	})
+}
+
+// CountPrefix returns the number of items in the tree whose key starts with
+// prefix.  It panics if the tree was created with Options.Compare.
+func (t *BTree) CountPrefix(prefix []byte) int {
+	t.checkPrefixOrder()
+	if t.root == nil {
+		return 0
+	}
+	from, to := prefixBounds(prefix)
+	end := t.length
+	if to != nil {
+		end = t.root.rank(to)
+	}
+	return end - t.root.rank(from)
+}
+
+// DeletePrefix removes every item whose key starts with prefix and returns
+// the number of items removed.  It works like DeleteRange, and panics if the
+// tree was created with Options.Compare.
+func (t *BTree) DeletePrefix(prefix []byte) int {
+	t.checkPrefixOrder()
+	from, to := prefixBounds(prefix)
+	return t.deleteRange(from, to)
+}
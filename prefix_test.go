@@ -0,0 +1,133 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	for _, tc := range []struct{ prefix, want []byte }{
+		{nil, nil},
+		{[]byte{}, nil},
+		{[]byte{0x01}, []byte{0x02}},
+		{[]byte{0x01, 0xff}, []byte{0x02}},
+		{[]byte{0x01, 0xfe, 0xff, 0xff}, []byte{0x01, 0xff}},
+		{[]byte{0xff, 0xff}, nil},
+	} {
+		if got := prefixEnd(tc.prefix); !bytes.Equal(got, tc.want) || (got == nil) != (tc.want == nil) {
+			t.Errorf("prefixEnd(%x) = %x, want %x", tc.prefix, got, tc.want)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	// Buckets 0x00, 0x01, 0xff and 0xff 0xff, with keys 0..99 each, plus the
+	// bare bucket prefixes themselves.
+	buckets := [][]byte{{0x00}, {0x01}, {0xff}, {0xff, 0xff}}
+	var all [][]byte
+	for _, b := range buckets {
+		all = append(all, b)
+		for i := 0; i < 100; i++ {
+			all = append(all, append(append([]byte(nil), b...), byte(i)))
+		}
+	}
+	tr := NewWithOptions(Options{Degree: 3})
+	for _, k := range all {
+		tr.ReplaceOrInsert(k, nil)
+	}
+	sorted, _ := rang0(all, make([][]byte, len(all)))
+
+	for _, prefix := range [][]byte{nil, {0x00}, {0x01}, {0x01, 0x05}, {0xff}, {0xff, 0xff}, {0xff, 0xff, 0xff}, {0x02}} {
+		var want [][]byte
+		for _, k := range sorted {
+			if bytes.HasPrefix(k, prefix) {
+				want = append(want, k)
+			}
+		}
+		var got [][]byte
+		tr.AscendPrefix(prefix, func(k, v []byte) bool {
+			got = append(got, k)
+			return true
+		})
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("ascendprefix %x:\n got: %x\nwant: %x", prefix, got, want)
+		}
+		got = got[:0]
+		tr.DescendPrefix(prefix, func(k, v []byte) bool {
+			got = append(got, k)
+			return true
+		})
+		for i := range got {
+			if !bytes.Equal(got[i], want[len(want)-1-i]) {
+				t.Fatalf("descendprefix %x:\n got: %x\nwant reverse of: %x", prefix, got, want)
+			}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("descendprefix %x: got %d keys, want %d", prefix, len(got), len(want))
+		}
+		if n := tr.CountPrefix(prefix); n != len(want) {
+			t.Fatalf("countprefix %x: got %d, want %d", prefix, n, len(want))
+		}
+	}
+
+	clone := tr.Clone()
+	if n := clone.DeletePrefix([]byte{0xff}); n != 202 {
+		t.Fatalf("deleteprefix ff: removed %d, want 202", n)
+	}
+	checkTree(t, clone)
+	if max, _ := clone.Max(); !bytes.Equal(max, []byte{0x01, 99}) {
+		t.Fatalf("max after deleteprefix: %x", max)
+	}
+	if n := clone.DeletePrefix([]byte{0x00}); n != 101 {
+		t.Fatalf("deleteprefix 00: removed %d, want 101", n)
+	}
+	checkTree(t, clone)
+	if n := tr.CountPrefix(nil); n != len(all) {
+		t.Fatalf("original tree changed: %d items, want %d", n, len(all))
+	}
+	if n := clone.DeletePrefix(nil); n != 101 || clone.Len() != 0 {
+		t.Fatalf("deleteprefix of everything: removed %d, %d left", n, clone.Len())
+	}
+}
+
+func TestPrefixCustomCompare(t *testing.T) {
+	tr := NewWithOptions(Options{Degree: 3, Compare: reverseCompare})
+	for i := 0; i < 50; i++ {
+		tr.ReplaceOrInsert([]byte{byte(i % 5), byte(i)}, nil)
+	}
+	for name, op := range map[string]func(){
+		"AscendPrefix":  func() { tr.AscendPrefix([]byte{1}, func([]byte, []byte) bool { return true }) },
+		"DescendPrefix": func() { tr.DescendPrefix([]byte{1}, func([]byte, []byte) bool { return true }) },
+		"CountPrefix":   func() { tr.CountPrefix([]byte{1}) },
+		"DeletePrefix":  func() { tr.DeletePrefix([]byte{1}) },
+		"clone":         func() { tr.Clone().CountPrefix([]byte{1}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s on a tree with a custom Compare didn't panic", name)
+				}
+			}()
+			op()
+		}()
+	}
+	if tr.Len() != 50 {
+		t.Fatalf("tree changed: %d items, want 50", tr.Len())
+	}
+	checkTree(t, tr)
+}
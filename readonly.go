@@ -0,0 +1,117 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+// ReadOnlyBTree is an immutable snapshot of a BTree, taken by Snapshot.  It
+// only offers read operations, which are safe for concurrent use.
+type ReadOnlyBTree struct {
+	// t is the tree as it was when the snapshot was taken.  Its write context
+	// is the one that owns the nodes it shares with the original tree, which
+	// nothing writes with anymore.
+	t BTree
+}
+
+// Snapshot returns a read-only view of the current contents of t.
+//
+// Like Clone, Snapshot is cheap: the nodes of t are shared with the snapshot,
+// and t only copies them as it writes to them.  Unlike Clone, it only gives t
+// a new write context, since the snapshot never writes.  Snapshot should not
+// be called concurrently with other calls on t, but the snapshot and t can be
+// used concurrently once it returns.
+func (t *BTree) Snapshot() *ReadOnlyBTree {
+	s := &ReadOnlyBTree{t: *t}
+	s.t.commits = nil
+	cow := *t.cow
+	if cow.arena != nil {
+		cow.arena = cow.arena.fork()
+	}
+	cow.dirty = nil
+	t.cow = &cow
+	return s
+}
+
+// Clone returns a tree holding the contents of s, to read and write.  Unlike
+// BTree.Clone, it leaves s untouched, so it may be called concurrently with
+// other calls on s, including Clone.
+func (s *ReadOnlyBTree) Clone() *BTree {
+	out := s.t
+	cow := *s.t.cow
+	if cow.arena != nil {
+		cow.arena = cow.arena.fork()
+	}
+	out.cow = &cow
+	return &out
+}
+
+// Get is BTree.Get.
+func (s *ReadOnlyBTree) Get(key []byte) ([]byte, bool) { return s.t.Get(key) }
+
+// Has is BTree.Has.
+func (s *ReadOnlyBTree) Has(key []byte) bool { return s.t.Has(key) }
+
+// Min is BTree.Min.
+func (s *ReadOnlyBTree) Min() ([]byte, []byte) { return s.t.Min() }
+
+// Max is BTree.Max.
+func (s *ReadOnlyBTree) Max() ([]byte, []byte) { return s.t.Max() }
+
+// Len is BTree.Len.
+func (s *ReadOnlyBTree) Len() int { return s.t.Len() }
+
+// Ascend is BTree.Ascend.
+func (s *ReadOnlyBTree) Ascend(iterator ItemIterator) { s.t.Ascend(iterator) }
+
+// AscendRange is BTree.AscendRange.
+func (s *ReadOnlyBTree) AscendRange(greaterOrEqual, lessThan []byte, iterator ItemIterator) {
+	s.t.AscendRange(greaterOrEqual, lessThan, iterator)
+}
+
+// AscendLessThan is BTree.AscendLessThan.
+func (s *ReadOnlyBTree) AscendLessThan(pivot []byte, iterator ItemIterator) {
+	s.t.AscendLessThan(pivot, iterator)
+}
+
+// AscendGreaterOrEqual is BTree.AscendGreaterOrEqual.
+func (s *ReadOnlyBTree) AscendGreaterOrEqual(pivot []byte, iterator ItemIterator) {
+	s.t.AscendGreaterOrEqual(pivot, iterator)
+}
+
+// AscendPrefix is BTree.AscendPrefix.
+func (s *ReadOnlyBTree) AscendPrefix(prefix []byte, iterator ItemIterator) {
+	s.t.AscendPrefix(prefix, iterator)
+}
+
+// Descend is BTree.Descend.
+func (s *ReadOnlyBTree) Descend(iterator ItemIterator) { s.t.Descend(iterator) }
+
+// DescendRange is BTree.DescendRange.
+func (s *ReadOnlyBTree) DescendRange(lessOrEqual, greaterThan []byte, iterator ItemIterator) {
+	s.t.DescendRange(lessOrEqual, greaterThan, iterator)
+}
+
+// DescendLessOrEqual is BTree.DescendLessOrEqual.
+func (s *ReadOnlyBTree) DescendLessOrEqual(pivot []byte, iterator ItemIterator) {
+	s.t.DescendLessOrEqual(pivot, iterator)
+}
+
+// DescendGreaterThan is BTree.DescendGreaterThan.
+func (s *ReadOnlyBTree) DescendGreaterThan(pivot []byte, iterator ItemIterator) {
+	s.t.DescendGreaterThan(pivot, iterator)
+}
+
+// DescendPrefix is BTree.DescendPrefix.
+func (s *ReadOnlyBTree) DescendPrefix(prefix []byte, iterator ItemIterator) {
+	s.t.DescendPrefix(prefix, iterator)
+}
@@ -0,0 +1,82 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSnapshotReadOnly(t *testing.T) {
+	for _, opts := range []Options{{Degree: 2}, {Degree: 3, CompressKeys: true}, {Degree: 4, ArenaChunkSize: 64}} {
+		tr := NewWithOptions(opts)
+		for i := 0; i < 200; i++ {
+			tr.ReplaceOrInsert(seqKey(i), seqKey(i))
+		}
+		snapCow := tr.cow
+		snap := tr.Snapshot()
+		if snap.t.cow != snapCow || tr.cow == snapCow {
+			t.Fatalf("snapshot must keep the original write context and the tree get a new one")
+		}
+		for i := 0; i < 200; i += 2 {
+			tr.Delete(seqKey(i))
+		}
+		for i := 200; i < 300; i++ {
+			tr.ReplaceOrInsert(seqKey(i), nil)
+		}
+		tr.ReplaceOrInsert(seqKey(1), []byte("new"))
+		checkTree(t, tr)
+
+		if snap.Len() != 200 {
+			t.Fatalf("snapshot Len = %d, want 200", snap.Len())
+		}
+		for i := 0; i < 200; i++ {
+			if v, ok := snap.Get(seqKey(i)); !ok || !bytes.Equal(v, seqKey(i)) {
+				t.Fatalf("snapshot Get(%d) = %x, %v", i, v, ok)
+			}
+		}
+		if snap.Has(seqKey(250)) {
+			t.Fatalf("snapshot sees a later insert")
+		}
+		if min, _ := snap.Min(); !bytes.Equal(min, seqKey(0)) {
+			t.Fatalf("snapshot Min = %x", min)
+		}
+		if max, _ := snap.Max(); !bytes.Equal(max, seqKey(199)) {
+			t.Fatalf("snapshot Max = %x", max)
+		}
+		i := 50
+		snap.AscendRange(seqKey(50), seqKey(100), func(k, v []byte) bool {
+			if !bytes.Equal(k, seqKey(i)) {
+				t.Fatalf("AscendRange: got %x, want %x", k, seqKey(i))
+			}
+			i++
+			return true
+		})
+		if i != 100 {
+			t.Fatalf("AscendRange stopped at %d", i)
+		}
+		i = 199
+		snap.Descend(func(k, v []byte) bool {
+			if !bytes.Equal(k, seqKey(i)) {
+				t.Fatalf("Descend: got %x, want %x", k, seqKey(i))
+			}
+			i--
+			return true
+		})
+		if i != -1 {
+			t.Fatalf("Descend stopped at %d", i)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import "errors"
+
+// ErrNoSavepoint is returned by Txn.RollbackTo and Txn.Release for a
+// savepoint that was never taken, or was already released or rolled back.
+var ErrNoSavepoint = errors.New("bytebtree: no such savepoint")
+
+// SavepointID identifies a savepoint taken by Txn.Savepoint.
+type SavepointID uint64
+
+// savepoint is the state of a transaction when a savepoint was taken.
+//
+// Taking a savepoint hands the transaction a new write context, so that the
+// nodes of the saved state, owned by cow, are copied rather than modified by
+// later writes.  Every node written after the savepoint is owned by a newer
+// context, which makes those nodes easy to find again: they are the ones
+// owned by a context newer than cow, and always sit above the older ones.
+type savepoint struct {
+	id     SavepointID
+	root   *node
+	length int
+	cow    *copyOnWriteContext
+	// live and dead are the byte counts of the arena, if any.
+	live, dead int
+}
+
+// Savepoint records the current state of the transaction, so that it can be
+// restored by RollbackTo.  Savepoints nest: rolling back to or releasing a
+// savepoint does the same to every savepoint taken after it.
+func (txn *Txn) Savepoint() SavepointID {
+	txn.lastID++
+	sp := savepoint{id: txn.lastID, root: txn.t.root, length: txn.t.length, cow: txn.t.cow}
+	if a := txn.t.cow.arena; a != nil {
+		sp.live, sp.dead = a.live, a.dead
+	}
+	txn.savepoints = append(txn.savepoints, sp)
+	cow := *txn.t.cow
+	cow.dirty = nil
+	txn.t.cow = &cow
+	return sp.id
+}
+
+// RollbackTo restores the transaction to the state it was in when savepoint
+// id was taken, and releases that savepoint along with every later one.  The
+// nodes written since are returned to the free list, and the transaction
+// owns the nodes of the restored state again.
+func (txn *Txn) RollbackTo(id SavepointID) error {
+	k := txn.findSavepoint(id)
+	if k < 0 {
+		return ErrNoSavepoint
+	}
+	txn.rollbackTo(k)
+	return nil
+}
+
+func (txn *Txn) rollbackTo(k int) {
+	for j := len(txn.savepoints) - 1; j > k; j-- {
+		if sp := txn.savepoints[j]; sp.root != nil {
+			sp.cow.freeOwned(sp.root)
+		}
+	}
+	if txn.t.root != nil {
+		txn.t.cow.freeOwned(txn.t.root)
+	}
+	sp := txn.savepoints[k]
+	txn.t.root, txn.t.length, txn.t.cow = sp.root, sp.length, sp.cow
+	if a := txn.t.cow.arena; a != nil {
+		a.live, a.dead = sp.live, sp.dead
+	}
+	txn.truncateSavepoints(k)
+}
+
+// Release forgets savepoint id and every later one, keeping the writes made
+// since.  The transaction takes back ownership of every node it wrote.
+func (txn *Txn) Release(id SavepointID) error {
+	k := txn.findSavepoint(id)
+	if k < 0 {
+		return ErrNoSavepoint
+	}
+	txn.release(k)
+	return nil
+}
+
+func (txn *Txn) release(k int) {
+	cow := txn.savepoints[k].cow
+	newer := make(map[*copyOnWriteContext]bool, len(txn.savepoints)-k)
+	for _, sp := range txn.savepoints[k+1:] {
+		newer[sp.cow] = true
+	}
+	newer[txn.t.cow] = true
+	if txn.t.root != nil {
+		rehome(txn.t.root, newer, cow)
+	}
+	// Whatever newer contexts still own is no longer reachable.
+	for _, sp := range txn.savepoints[k+1:] {
+		if sp.root != nil {
+			sp.cow.freeOwned(sp.root)
+		}
+	}
+	cow.arena = txn.t.cow.arena
+	txn.t.cow = cow
+	txn.truncateSavepoints(k)
+}
+
+// rehome hands the nodes of the subtree rooted at n that are owned by one of
+// the from contexts over to to.
+func rehome(n *node, from map[*copyOnWriteContext]bool, to *copyOnWriteContext) {
+	if !from[n.cow] {
+		return
+	}
+	n.cow = to
+	for _, child := range n.children {
+		rehome(child, from, to)
+	}
+}
+
+// Snapshot returns a read-only view of the current contents of the
+// transaction, as BTree.Snapshot does.
+//
+// The snapshot shares nodes written before and after the savepoints held, so
+// those savepoints give up the nodes they own: rolling back to or releasing
+// them afterwards copies the nodes the transaction writes again, rather than
+// freeing or modifying them.
+func (txn *Txn) Snapshot() *ReadOnlyBTree {
+	for k := range txn.savepoints {
+		cow := *txn.savepoints[k].cow
+		if cow.arena != nil {
+			cow.arena = cow.arena.fork()
+		}
+		cow.dirty = nil
+		txn.savepoints[k].cow = &cow
+	}
+	return txn.t.Snapshot()
+}
+
+// findSavepoint returns the index of savepoint id, or -1.
+func (txn *Txn) findSavepoint(id SavepointID) int {
+	for k, sp := range txn.savepoints {
+		if sp.id == id {
+			return k
+		}
+	}
+	return -1
+}
+
+// truncateSavepoints forgets savepoint k and every later one.
+func (txn *Txn) truncateSavepoints(k int) {
+	for j := k; j < len(txn.savepoints); j++ {
+		txn.savepoints[j] = savepoint{}
+	}
+	txn.savepoints = txn.savepoints[:k]
+}
@@ -0,0 +1,197 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSavepoint(t *testing.T) {
+	for _, opts := range []Options{
+		{Degree: 3},
+		{Degree: 3, ArenaChunkSize: 64},
+		{Degree: 3, CompressKeys: true},
+	} {
+		fl := NewFreeList(1000)
+		opts.FreeList = fl
+		tr := NewWithOptions(opts)
+		for i := 0; i < 100; i++ {
+			tr.ReplaceOrInsert(seqKey(i), nil)
+		}
+		txn := tr.Begin()
+		txn.DeleteRange(seqKey(50), seqKey(100))
+		sp1 := txn.Savepoint()
+		for i := 100; i < 200; i++ {
+			txn.ReplaceOrInsert(seqKey(i), nil)
+		}
+		sp2 := txn.Savepoint()
+		txn.DeleteRange(seqKey(0), seqKey(150))
+		checkSeq(t, txn.t, 150, 200)
+
+		free := len(fl.freelist)
+		root := txn.t.root
+		if err := txn.RollbackTo(sp2); err != nil {
+			t.Fatalf("RollbackTo: %v", err)
+		}
+		checkTree(t, txn.t)
+		if txn.Len() != 150 || txn.CountRange(seqKey(0), seqKey(50)) != 50 || txn.CountRange(seqKey(100), seqKey(200)) != 100 {
+			t.Fatalf("tree doesn't hold [0, 50) and [100, 200) after RollbackTo")
+		}
+		if got := len(fl.freelist); got <= free {
+			t.Fatalf("free list holds %d nodes after RollbackTo, %d before", got, free)
+		}
+		if root.cow != nil {
+			t.Fatalf("root written after the savepoint was not freed")
+		}
+		if err := txn.RollbackTo(sp2); !errors.Is(err, ErrNoSavepoint) {
+			t.Fatalf("second RollbackTo: got %v, want %v", err, ErrNoSavepoint)
+		}
+
+		// The transaction owns the nodes it wrote before sp2 again, and keeps
+		// writing them in place.
+		root = txn.t.root
+		txn.ReplaceOrInsert(seqKey(200), nil)
+		if txn.t.root != root {
+			t.Fatalf("write after RollbackTo copied the root")
+		}
+		if txn.Len() != 151 {
+			t.Fatalf("Len: got %d, want 151", txn.Len())
+		}
+
+		// Releasing a savepoint keeps the writes made since, and hands their
+		// nodes back to the transaction.
+		sp3 := txn.Savepoint()
+		txn.DeleteRange(seqKey(100), seqKey(201))
+		root = txn.t.root
+		if err := txn.Release(sp1); err != nil {
+			t.Fatalf("Release: %v", err)
+		}
+		if err := txn.RollbackTo(sp3); !errors.Is(err, ErrNoSavepoint) {
+			t.Fatalf("RollbackTo a savepoint taken after a released one: got %v, want %v", err, ErrNoSavepoint)
+		}
+		txn.ReplaceOrInsert(seqKey(50), nil)
+		if txn.t.root != root {
+			t.Fatalf("write after Release copied the root")
+		}
+		checkSeq(t, txn.t, 0, 51)
+		if a := txn.t.cow.arena; a != nil && a.live != 51*4 {
+			t.Fatalf("arena holds %d live bytes for 51 items", a.live)
+		}
+
+		checkSeq(t, tr, 0, 100)
+		if err := txn.Commit(); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+		checkSeq(t, tr, 0, 51)
+		if opts.CompressKeys {
+			checkPacked(t, tr)
+		}
+	}
+}
+
+func TestSavepointRollback(t *testing.T) {
+	fl := NewFreeList(1000)
+	tr := NewWithOptions(Options{Degree: 3, FreeList: fl})
+	for i := 0; i < 100; i++ {
+		tr.ReplaceOrInsert(seqKey(i), nil)
+	}
+	txn := tr.Begin()
+	for i := 100; i < 150; i++ {
+		txn.ReplaceOrInsert(seqKey(i), nil)
+	}
+	txn.Savepoint()
+	for i := 150; i < 200; i++ {
+		txn.ReplaceOrInsert(seqKey(i), nil)
+	}
+	txn.Savepoint()
+	txn.DeleteRange(seqKey(0), seqKey(200))
+
+	// Rollback frees the nodes written before and after the savepoints.
+	txn.Rollback()
+	if got := len(fl.freelist); got < 20 {
+		t.Fatalf("free list holds %d nodes after rollback", got)
+	}
+	checkSeq(t, tr, 0, 100)
+	tr.ReplaceOrInsert(seqKey(100), nil)
+	checkSeq(t, tr, 0, 101)
+}
+
+func TestSavepointSnapshot(t *testing.T) {
+	for _, opts := range []Options{
+		{Degree: 3},
+		{Degree: 3, CompressKeys: true},
+	} {
+		fl := NewFreeList(1000)
+		opts.FreeList = fl
+		tr := NewWithOptions(opts)
+		for i := 0; i < 100; i++ {
+			tr.ReplaceOrInsert(seqKey(i), nil)
+		}
+		txn := tr.Begin()
+		sp1 := txn.Savepoint()
+		for i := 100; i < 200; i++ {
+			txn.ReplaceOrInsert(seqKey(i), nil)
+		}
+		sp2 := txn.Savepoint()
+		txn.DeleteRange(seqKey(0), seqKey(50))
+		s := txn.Snapshot()
+		check := func(when string) {
+			t.Helper()
+			var got []int
+			s.Ascend(func(k, v []byte) bool {
+				got = append(got, int(k[3])|int(k[2])<<8)
+				return true
+			})
+			if len(got) != 150 || got[0] != 50 || got[149] != 199 {
+				t.Fatalf("snapshot %s: got %d keys %v", when, len(got), got)
+			}
+		}
+
+		// Rolling back frees nothing the snapshot shares, and the nodes
+		// reused from the free list leave it intact.
+		if err := txn.RollbackTo(sp2); err != nil {
+			t.Fatalf("RollbackTo: %v", err)
+		}
+		for i := 200; i < 300; i++ {
+			txn.ReplaceOrInsert(seqKey(i), nil)
+		}
+		check("after RollbackTo")
+		if err := txn.Release(sp1); err != nil {
+			t.Fatalf("Release: %v", err)
+		}
+		txn.DeleteRange(seqKey(100), seqKey(250))
+		check("after Release")
+		checkTree(t, txn.t)
+		if txn.Len() != 150 || txn.CountRange(seqKey(0), seqKey(100)) != 100 {
+			t.Fatalf("transaction doesn't hold [0, 100) and [250, 300)")
+		}
+
+		sp3 := txn.Savepoint()
+		txn.DeleteRange(seqKey(0), seqKey(300))
+		s = txn.Snapshot()
+		if err := txn.RollbackTo(sp3); err != nil {
+			t.Fatalf("RollbackTo: %v", err)
+		}
+		txn.Rollback()
+		for i := 0; i < 300; i++ {
+			tr.ReplaceOrInsert(seqKey(i), nil)
+		}
+		if s.Len() != 0 {
+			t.Fatalf("empty snapshot holds %d items", s.Len())
+		}
+		checkSeq(t, tr, 0, 300)
+	}
+}
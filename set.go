@@ -0,0 +1,154 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+// Set is an ordered set of []byte keys.
+//
+// It is a BTree whose values are all nil, so that no value is ever allocated.
+type Set struct {
+	t *BTree
+}
+
+// SetOptions configures a set created by NewSetWithOptions.
+type SetOptions = MapOptions
+
+// KeyIterator allows callers of Set.Ascend* and Set.Descend* to iterate
+// in-order over portions of a set.  When this function returns false,
+// iteration stops.
+type KeyIterator func(k []byte) bool
+
+func (iter KeyIterator) items() ItemIterator {
+	return func(k, _ []byte) bool { return iter(k) }
+}
+
+// NewSet creates a new set of the default Degree.
+func NewSet() *Set {
+	return NewSetWithOptions(SetOptions{})
+}
+
+// NewSetWithOptions creates a new set configured by opts.
+//
+// It panics if opts.Degree is less than 2 (and not zero).
+func NewSetWithOptions(opts SetOptions) *Set {
+	return &Set{t: newMapTree(opts)}
+}
+
+// Clone clones the set, lazily, as BTree.Clone does.
+func (s *Set) Clone() *Set {
+	return &Set{t: s.t.Clone()}
+}
+
+// Insert adds k to the set, and reports whether it was not already there.
+//
+// A nil key cannot be added to the set (will panic).
+func (s *Set) Insert(k []byte) bool {
+	old, _ := s.t.ReplaceOrInsert(k, nil)
+	return old == nil
+}
+
+// Delete removes k from the set, and reports whether it was there.
+func (s *Set) Delete(k []byte) bool {
+	old, _ := s.t.Delete(k)
+	return old != nil
+}
+
+// DeleteMin removes the smallest key in the set and returns it, or nil if the
+// set is empty.
+func (s *Set) DeleteMin() []byte {
+	k, _ := s.t.DeleteMin()
+	return k
+}
+
+// DeleteMax removes the largest key in the set and returns it, or nil if the
+// set is empty.
+func (s *Set) DeleteMax() []byte {
+	k, _ := s.t.DeleteMax()
+	return k
+}
+
+// Has returns true if k is in the set.
+func (s *Set) Has(k []byte) bool {
+	return s.t.Has(k)
+}
+
+// Min returns the smallest key in the set, or nil if the set is empty.
+func (s *Set) Min() []byte {
+	k, _ := s.t.Min()
+	return k
+}
+
+// Max returns the largest key in the set, or nil if the set is empty.
+func (s *Set) Max() []byte {
+	k, _ := s.t.Max()
+	return k
+}
+
+// Len returns the number of keys currently in the set.
+func (s *Set) Len() int {
+	return s.t.Len()
+}
+
+// Clear removes all keys from the set, as BTree.Clear does.
+func (s *Set) Clear(addNodesToFreelist bool) {
+	s.t.Clear(addNodesToFreelist)
+}
+
+// AscendRange calls the iterator for every key in the set within the range
+// [greaterOrEqual, lessThan), until iterator returns false.
+func (s *Set) AscendRange(greaterOrEqual, lessThan []byte, iterator KeyIterator) {
+	s.t.AscendRange(greaterOrEqual, lessThan, iterator.items())
+}
+
+// AscendLessThan calls the iterator for every key in the set within the range
+// [first, pivot), until iterator returns false.
+func (s *Set) AscendLessThan(pivot []byte, iterator KeyIterator) {
+	s.t.AscendLessThan(pivot, iterator.items())
+}
+
+// AscendGreaterOrEqual calls the iterator for every key in the set within the
+// range [pivot, last], until iterator returns false.
+func (s *Set) AscendGreaterOrEqual(pivot []byte, iterator KeyIterator) {
+	s.t.AscendGreaterOrEqual(pivot, iterator.items())
+}
+
+// Ascend calls the iterator for every key in the set within the range
+// [first, last], until iterator returns false.
+func (s *Set) Ascend(iterator KeyIterator) {
+	s.t.Ascend(iterator.items())
+}
+
+// DescendRange calls the iterator for every key in the set within the range
+// [lessOrEqual, greaterThan), until iterator returns false.
+func (s *Set) DescendRange(lessOrEqual, greaterThan []byte, iterator KeyIterator) {
+	s.t.DescendRange(lessOrEqual, greaterThan, iterator.items())
+}
+
+// DescendLessOrEqual calls the iterator for every key in the set within the
+// range [pivot, first], until iterator returns false.
+func (s *Set) DescendLessOrEqual(pivot []byte, iterator KeyIterator) {
+	s.t.DescendLessOrEqual(pivot, iterator.items())
+}
+
+// DescendGreaterThan calls the iterator for every key in the set within the
+// range [last, pivot), until iterator returns false.
+func (s *Set) DescendGreaterThan(pivot []byte, iterator KeyIterator) {
+	s.t.DescendGreaterThan(pivot, iterator.items())
+}
+
+// Descend calls the iterator for every key in the set within the range
+// [last, first], until iterator returns false.
+func (s *Set) Descend(iterator KeyIterator) {
+	s.t.Descend(iterator.items())
+}
@@ -0,0 +1,96 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestSetValues(t *testing.T) {
+	s := NewSet()
+	for i := 0; i < 100; i++ {
+		s.Insert(seqKey(i))
+	}
+	s.t.Ascend(func(k, v []byte) bool {
+		if v != nil {
+			t.Fatalf("key %x holds value %x", k, v)
+		}
+		return true
+	})
+}
+
+func TestSet(t *testing.T) {
+	const size = 500
+	s := NewSetWithOptions(SetOptions{Degree: 2})
+	for _, i := range rand.Perm(size) {
+		if !s.Insert(seqKey(i)) {
+			t.Fatalf("insert %d: reported as present", i)
+		}
+	}
+	if s.Insert(seqKey(7)) {
+		t.Fatalf("second insert reported as new")
+	}
+	checkTree(t, s.t)
+	if s.Len() != size || !s.Has(seqKey(0)) || s.Has(seqKey(size)) {
+		t.Fatalf("bad contents: len %d", s.Len())
+	}
+	if !reflect.DeepEqual(s.Min(), seqKey(0)) || !reflect.DeepEqual(s.Max(), seqKey(size-1)) {
+		t.Fatalf("min %x, max %x", s.Min(), s.Max())
+	}
+
+	c := s.Clone()
+	for i := 0; i < size; i += 2 {
+		if !s.Delete(seqKey(i)) {
+			t.Fatalf("delete %d: not found", i)
+		}
+	}
+	checkTree(t, s.t)
+	checkTree(t, c.t)
+	if s.Len() != size/2 || c.Len() != size {
+		t.Fatalf("lens: got %d and %d", s.Len(), c.Len())
+	}
+
+	var got [][]byte
+	s.AscendRange(seqKey(10), seqKey(20), func(k []byte) bool {
+		got = append(got, k)
+		return true
+	})
+	want := [][]byte{seqKey(11), seqKey(13), seqKey(15), seqKey(17), seqKey(19)}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ascend range: got %x, want %x", got, want)
+	}
+	got = got[:0]
+	s.DescendRange(seqKey(19), seqKey(15), func(k []byte) bool {
+		got = append(got, k)
+		return true
+	})
+	want = [][]byte{seqKey(19), seqKey(17)}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("descend range: got %x, want %x", got, want)
+	}
+
+	if k := s.DeleteMin(); !reflect.DeepEqual(k, seqKey(1)) {
+		t.Fatalf("delete min: got %x", k)
+	}
+	if k := s.DeleteMax(); !reflect.DeepEqual(k, seqKey(size-1)) {
+		t.Fatalf("delete max: got %x", k)
+	}
+	s.Clear(true)
+	if s.Len() != 0 || s.Min() != nil {
+		t.Fatalf("set not empty after clear")
+	}
+}
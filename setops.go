@@ -0,0 +1,145 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+// Set operations.
+//
+// Union, Intersect and Difference walk the second tree from its root, cut the
+// first tree at each of the root's keys with the splicer, combine each piece
+// with the child of the root that covers the same keys, and glue the results
+// back together.  A piece that is empty, or a child that the two trees share,
+// ends the recursion, so whole subtrees of either tree end up in the result
+// untouched.  This costs about O(m log(n/m)) for trees of m and n items whose
+// keys interleave little, rather than the O(m + n) of a merge.
+//
+// The result shares nodes with both inputs, so both are given new write
+// contexts, as Clone does, before they can write to those nodes again.
+
+// ResolveFunc returns the value to keep for a key present with value va in
+// the first tree and vb in the second.
+type ResolveFunc func(k, va, vb []byte) []byte
+
+// Union returns a new tree holding every item of a and b.  For a key present
+// in both, it holds resolve(k, va, vb), or the item of b if resolve is nil.
+//
+// a and b must order keys the same way, and have the same Degree and
+// CompressKeys options, which the result takes from a.
+func Union(a, b *BTree, resolve ResolveFunc) *BTree {
+	return combine(setUnion, a, b, resolve)
+}
+
+// Intersect returns a new tree holding an item for every key present in both
+// a and b: resolve(k, va, vb), or the item of b if resolve is nil.  a and b
+// must be compatible, as for Union.
+func Intersect(a, b *BTree, resolve ResolveFunc) *BTree {
+	return combine(setIntersect, a, b, resolve)
+}
+
+// Difference returns a new tree holding the items of a whose keys are not
+// present in b.  a and b must be compatible, as for Union.
+func Difference(a, b *BTree) *BTree {
+	return combine(setDifference, a, b, nil)
+}
+
+type setOp int
+
+const (
+	setUnion setOp = iota
+	setIntersect
+	setDifference
+)
+
+func combine(op setOp, a, b *BTree, resolve ResolveFunc) *BTree {
+	checkCompatible(a, b)
+	a.disown()
+	b.disown()
+	out := a.derive()
+	if op == setUnion && out.cow.arena != nil && b.cow.arena != nil {
+		out.cow.arena.live += b.cow.arena.live
+	}
+	s := out.splicer()
+	ra, ha := subtree(a.root)
+	rb, hb := subtree(b.root)
+	out.root, _ = s.combine(op, ra, ha, rb, hb, resolve)
+	if out.root != nil {
+		out.length = out.root.count
+	}
+	out.cow.packDirty()
+	return out
+}
+
+// combine applies op to the subtrees rooted at a and b.
+func (s splicer) combine(op setOp, a *node, ha int, b *node, hb int, resolve ResolveFunc) (*node, int) {
+	switch {
+	case a == nil:
+		if op == setUnion {
+			return b, hb
+		}
+		return nil, 0
+	case b == nil:
+		if op == setIntersect {
+			return nil, 0
+		}
+		return a, ha
+	case a == b:
+		if op == setDifference {
+			return nil, 0
+		}
+		if resolve == nil {
+			return a, ha
+		}
+	}
+	var out *node
+	ho := 0
+	var sep Item
+	hasSep := false
+	rest, hr := a, ha
+	for i := 0; i <= len(b.items); i++ {
+		// l is the part of a that sorts before b.items[i], and b.children[i]
+		// the part of b.
+		l, hl := rest, hr
+		var next Item
+		hasNext := false
+		if i < len(b.items) {
+			item := b.item(i)
+			var r *node
+			var hrr int
+			l, hl, r, hrr = s.splitBefore(rest, hr, &item)
+			inA := r != nil && s.cow.compare(min(r)[0], item[0]) == 0
+			if inA {
+				var old Item
+				old, r, hrr = s.popMin(r, hrr)
+				if resolve != nil {
+					_, item[1] = s.cow.own(nil, resolve(item[0], old[1], item[1]))
+				}
+			}
+			rest, hr = r, hrr
+			next = item
+			hasNext = op == setUnion || op == setIntersect && inA
+		}
+		var child *node
+		if len(b.children) > 0 {
+			child = b.children[i]
+		}
+		sub, hs := s.combine(op, l, hl, child, hb-1, resolve)
+		if hasSep {
+			out, ho = s.join(out, ho, sep, sub, hs)
+		} else {
+			out, ho = s.concat(out, ho, sub, hs)
+		}
+		sep, hasSep = next, hasNext
+	}
+	return out, ho
+}
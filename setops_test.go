@@ -0,0 +1,176 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// contents returns the items of tr as a map.
+func contents(tr *BTree) map[string]string {
+	out := make(map[string]string)
+	tr.Ascend(func(k, v []byte) bool {
+		out[string(k)] = string(v)
+		return true
+	})
+	return out
+}
+
+func checkContents(t *testing.T, tr *BTree, want map[string]string) {
+	t.Helper()
+	checkTree(t, tr)
+	if got := contents(tr); !reflect.DeepEqual(got, want) {
+		keys := make([]string, 0, len(got))
+		for k := range got {
+			keys = append(keys, fmt.Sprintf("%x", k))
+		}
+		sort.Strings(keys)
+		t.Fatalf("tree holds %d items %v, want %d", len(got), keys, len(want))
+	}
+}
+
+func concatValues(k, va, vb []byte) []byte {
+	return append(append([]byte(nil), va...), vb...)
+}
+
+// checkSetOps checks the set operations on a and b, which hold ca and cb.
+func checkSetOps(t *testing.T, a, b *BTree, ca, cb map[string]string) {
+	t.Helper()
+	union, unionNil := make(map[string]string), make(map[string]string)
+	inter, interNil := make(map[string]string), make(map[string]string)
+	diff := make(map[string]string)
+	for k, v := range ca {
+		union[k], unionNil[k] = v, v
+		if vb, ok := cb[k]; ok {
+			inter[k], interNil[k] = v+vb, vb
+		} else {
+			diff[k] = v
+		}
+	}
+	for k, vb := range cb {
+		unionNil[k] = vb
+		union[k] = ca[k] + vb
+	}
+	checkContents(t, Union(a, b, concatValues), union)
+	checkContents(t, Union(a, b, nil), unionNil)
+	checkContents(t, Intersect(a, b, concatValues), inter)
+	checkContents(t, Intersect(a, b, nil), interNil)
+	checkContents(t, Difference(a, b), diff)
+}
+
+func TestSetOps(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, opts := range []Options{{Degree: 2}, {Degree: 3}, {Degree: 16}, {Degree: 3, CompressKeys: true}, {Degree: 3, ArenaChunkSize: 64}} {
+		for _, tc := range []struct {
+			name string
+			make func() (a, b *BTree)
+		}{
+			{"Disjoint", func() (a, b *BTree) {
+				a, b = NewWithOptions(opts), NewWithOptions(opts)
+				for i := 0; i < 500; i++ {
+					a.ReplaceOrInsert(seqKey(i), []byte("a"))
+					b.ReplaceOrInsert(seqKey(1000+i), []byte("b"))
+				}
+				return a, b
+			}},
+			{"Interleaved", func() (a, b *BTree) {
+				a, b = NewWithOptions(opts), NewWithOptions(opts)
+				for i := 0; i < 1000; i++ {
+					if r.Intn(3) > 0 {
+						a.ReplaceOrInsert(seqKey(i), []byte("a"))
+					}
+					if r.Intn(3) > 0 {
+						b.ReplaceOrInsert(seqKey(i), []byte("b"))
+					}
+				}
+				return a, b
+			}},
+			{"Clones", func() (a, b *BTree) {
+				a = NewWithOptions(opts)
+				for i := 0; i < 1000; i++ {
+					a.ReplaceOrInsert(seqKey(i), []byte("a"))
+				}
+				b = a.Clone()
+				for i := 0; i < 50; i++ {
+					b.Delete(seqKey(r.Intn(1000)))
+					b.ReplaceOrInsert(seqKey(r.Intn(1100)), []byte("b"))
+				}
+				return a, b
+			}},
+			{"Empty", func() (a, b *BTree) {
+				a, b = NewWithOptions(opts), NewWithOptions(opts)
+				for i := 0; i < 100; i++ {
+					a.ReplaceOrInsert(seqKey(i), []byte("a"))
+				}
+				return a, b
+			}},
+		} {
+			t.Run(fmt.Sprintf("degree=%d/compress=%v/arena=%v/%s", opts.Degree, opts.CompressKeys, opts.ArenaChunkSize > 0, tc.name), func(t *testing.T) {
+				a, b := tc.make()
+				ca, cb := contents(a), contents(b)
+				checkSetOps(t, a, b, ca, cb)
+				checkSetOps(t, b, a, cb, ca)
+
+				// The inputs are untouched, and writing to them doesn't change
+				// the results.
+				checkContents(t, a, ca)
+				checkContents(t, b, cb)
+				u := Union(a, b, nil)
+				want := contents(u)
+				a.DeleteRange(nil, seqKey(2000))
+				b.DeleteRange(nil, seqKey(2000))
+				checkContents(t, u, want)
+				if opts.CompressKeys {
+					checkPacked(t, u)
+				}
+			})
+		}
+	}
+}
+
+func TestSetOpsBadOptions(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Union of trees of different degrees didn't panic")
+		}
+	}()
+	Union(New(), NewWithOptions(Options{Degree: 3}), nil)
+}
+
+func BenchmarkUnion(b *testing.B) {
+	const size = 1_000_000
+	for _, bc := range []struct {
+		name   string
+		offset int
+	}{
+		{"Disjoint", size},
+		{"Interleaved", 0},
+	} {
+		l, r := New(), New()
+		for i := 0; i < size; i++ {
+			l.ReplaceOrInsert(seqKey(2*i), nil)
+			r.ReplaceOrInsert(seqKey(2*(i+bc.offset)+1), nil)
+		}
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Union(l, r, nil)
+			}
+		})
+	}
+}
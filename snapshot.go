@@ -0,0 +1,269 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// A snapshot, as written by WriteTo, is laid out as:
+//
+//	magic    [4]byte  "bbtr"
+//	version  byte     snapshotVersion
+//	count    uvarint  number of items
+//	items    count times: uvarint len(key), key, uvarint len(value)+1, value
+//	checksum uint32   big-endian CRC-32C of every byte above
+//
+// Items are written in ascending key order.  A nil value is written with a
+// length of 0, so that it reads back as nil rather than empty.  Version 1
+// snapshots, which wrote len(value) and so couldn't tell the two apart, are
+// still read, with every nil value read back as empty.
+const (
+	snapshotMagic   = "bbtr"
+	snapshotVersion = 2
+
+	// maxSnapshotField bounds the length of a key or value read from a
+	// snapshot, so that a corrupted length can't trigger a huge allocation.
+	maxSnapshotField = 1 << 30
+)
+
+// ErrBadSnapshot is returned by ReadFrom when its input is not a valid
+// snapshot.
+var ErrBadSnapshot = errors.New("bytebtree: malformed snapshot")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// WriteTo writes a snapshot of every item in t, in ascending order, to w.  It
+// implements io.WriterTo.
+//
+// The snapshot can be loaded back with ReadFrom.  It only holds items: the
+// degree and comparator of the tree that reads it are its own.
+func (t *BTree) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	crc := crc32.New(castagnoli)
+	out := io.MultiWriter(bw, crc)
+
+	var scratch [binary.MaxVarintLen64]byte
+	putUvarint := func(x uint64) error {
+		_, err := out.Write(scratch[:binary.PutUvarint(scratch[:], x)])
+		return err
+	}
+	if _, err := io.WriteString(out, snapshotMagic); err != nil {
+		return cw.n, err
+	}
+	if _, err := out.Write([]byte{snapshotVersion}); err != nil {
+		return cw.n, err
+	}
+	if err := putUvarint(uint64(t.length)); err != nil {
+		return cw.n, err
+	}
+	var err error
+	t.Ascend(func(k, v []byte) bool {
+		if err = putUvarint(uint64(len(k))); err != nil {
+			return false
+		}
+		if _, err = out.Write(k); err != nil {
+			return false
+		}
+		n := uint64(0)
+		if v != nil {
+			n = uint64(len(v)) + 1
+		}
+		if err = putUvarint(n); err != nil {
+			return false
+		}
+		_, err = out.Write(v)
+		return err == nil
+	})
+	if err != nil {
+		return cw.n, err
+	}
+	binary.BigEndian.PutUint32(scratch[:4], crc.Sum32())
+	if _, err := bw.Write(scratch[:4]); err != nil {
+		return cw.n, err
+	}
+	err = bw.Flush()
+	return cw.n, err
+}
+
+// ReadFrom replaces the contents of t with the items of a snapshot written by
+// WriteTo, read from r.  It implements io.ReaderFrom.
+//
+// The tree is rebuilt bottom-up as it is read, like BulkLoad does.  If the
+// snapshot is malformed, fails its checksum or holds keys out of order for
+// t's comparator, ReadFrom returns an error and t is left unchanged.
+//
+// ReadFrom reads exactly one snapshot if r implements io.ByteReader;
+// otherwise r is buffered, and bytes following the snapshot may be consumed.
+func (t *BTree) ReadFrom(r io.Reader) (int64, error) {
+	sr := &snapshotReader{crc: crc32.New(castagnoli)}
+	if br, ok := r.(byteReader); ok {
+		sr.r = br
+	} else {
+		sr.r = bufio.NewReader(r)
+	}
+
+	var header [len(snapshotMagic) + 1]byte
+	if _, err := io.ReadFull(sr, header[:]); err != nil {
+		return sr.n, snapshotError(err)
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return sr.n, fmt.Errorf("%w: bad magic %q", ErrBadSnapshot, header[:len(snapshotMagic)])
+	}
+	switch v := header[len(snapshotMagic)]; v {
+	case 1:
+	case snapshotVersion:
+		sr.nilValues = true
+	default:
+		return sr.n, fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, v)
+	}
+	count, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return sr.n, snapshotError(err)
+	}
+
+	b := bulkBuilder{cow: t.cow, maxItems: t.maxItems(), minItems: t.minItems()}
+	a := t.cow.loadArena()
+	var prev []byte
+	for i := uint64(0); i < count; i++ {
+		k, v, err := sr.readItem()
+		if err == nil {
+			err = b.check(prev, k)
+		}
+		if err != nil {
+			b.abort()
+			return sr.n, err
+		}
+		if a != nil {
+			k, v = a.own(k, v)
+		}
+		b.add(Item{k, v})
+		prev = k
+	}
+
+	want := sr.crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(sr.r, sum[:]); err != nil {
+		b.abort()
+		return sr.n, snapshotError(err)
+	}
+	sr.n += int64(len(sum))
+	if got := binary.BigEndian.Uint32(sum[:]); got != want {
+		b.abort()
+		return sr.n, fmt.Errorf("%w: checksum %08x, want %08x", ErrBadSnapshot, got, want)
+	}
+
+	root, length := b.finish()
+	if t.root != nil {
+		t.root.reset(t.cow)
+	}
+	t.root, t.length = root, length
+	if a != nil {
+		t.cow.arena = a
+	}
+	return sr.n, nil
+}
+
+// snapshotError reports a truncated snapshot as malformed.
+func snapshotError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %v", ErrBadSnapshot, io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// snapshotReader counts and checksums the bytes read through it.
+type snapshotReader struct {
+	r   byteReader
+	crc hash.Hash32
+	n   int64
+	// nilValues is set if value lengths are offset by one, 0 standing for a
+	// nil value.
+	nilValues bool
+}
+
+func (sr *snapshotReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	sr.crc.Write(p[:n])
+	sr.n += int64(n)
+	return n, err
+}
+
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	c, err := sr.r.ReadByte()
+	if err == nil {
+		sr.crc.Write([]byte{c})
+		sr.n++
+	}
+	return c, err
+}
+
+// readItem reads the next key and value.
+func (sr *snapshotReader) readItem() (k, v []byte, err error) {
+	if k, err = sr.readField(false); err != nil {
+		return nil, nil, err
+	}
+	if v, err = sr.readField(sr.nilValues); err != nil {
+		return nil, nil, err
+	}
+	return k, v, nil
+}
+
+// readField reads a length-prefixed byte string into a new slice.  If
+// nilable is set, the length is offset by one, and 0 reads as nil.
+func (sr *snapshotReader) readField(nilable bool) ([]byte, error) {
+	n, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return nil, snapshotError(err)
+	}
+	if nilable {
+		if n == 0 {
+			return nil, nil
+		}
+		n--
+	}
+	if n > maxSnapshotField {
+		return nil, fmt.Errorf("%w: field of %d bytes", ErrBadSnapshot, n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(sr, b); err != nil {
+		return nil, snapshotError(err)
+	}
+	return b, nil
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
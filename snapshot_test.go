@@ -0,0 +1,192 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 7, 300, 5000} {
+		keys, values := perm(size)
+		src := NewWithOptions(Options{Degree: 3})
+		for i := range keys {
+			src.ReplaceOrInsert(keys[i], values[i])
+		}
+		var buf bytes.Buffer
+		n, err := src.WriteTo(&buf)
+		if err != nil {
+			t.Fatalf("size %d: write: %v", size, err)
+		}
+		if n != int64(buf.Len()) {
+			t.Fatalf("size %d: wrote %d bytes, reported %d", size, buf.Len(), n)
+		}
+		// Trailing bytes must be left for the next reader.
+		buf.WriteString("trailer")
+
+		dst := NewWithOptions(Options{Degree: 2})
+		dst.ReplaceOrInsert([]byte("old"), nil)
+		m, err := dst.ReadFrom(&buf)
+		if err != nil {
+			t.Fatalf("size %d: read: %v", size, err)
+		}
+		if m != n {
+			t.Fatalf("size %d: read %d bytes, want %d", size, m, n)
+		}
+		if buf.String() != "trailer" {
+			t.Fatalf("size %d: left %q unread", size, buf.String())
+		}
+		checkTree(t, dst)
+		wantKeys, wantValues := all(src)
+		gotKeys, gotValues := all(dst)
+		if !reflect.DeepEqual(gotKeys, wantKeys) || !reflect.DeepEqual(gotValues, wantValues) {
+			t.Fatalf("size %d: loaded tree differs", size)
+		}
+	}
+}
+
+func TestSnapshotNilValues(t *testing.T) {
+	for _, opts := range []Options{{Degree: 2}, {Degree: 2, ArenaChunkSize: 64}} {
+		src := NewWithOptions(opts)
+		src.ReplaceOrInsert([]byte("empty"), []byte{})
+		src.ReplaceOrInsert([]byte("nil"), nil)
+		src.ReplaceOrInsert([]byte("value"), []byte("v"))
+		var buf bytes.Buffer
+		if _, err := src.WriteTo(&buf); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		dst := NewWithOptions(opts)
+		if _, err := dst.ReadFrom(&buf); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if v, ok := dst.Get([]byte("nil")); !ok || v != nil {
+			t.Fatalf("nil value read back as %#v, %v", v, ok)
+		}
+		if v, ok := dst.Get([]byte("empty")); !ok || v == nil || len(v) != 0 {
+			t.Fatalf("empty value read back as %#v, %v", v, ok)
+		}
+		if v, _ := dst.Get([]byte("value")); string(v) != "v" {
+			t.Fatalf("value read back as %q", v)
+		}
+	}
+
+	// Version 1 snapshots wrote the length of a value as is.
+	v1 := append([]byte(snapshotMagic), 1, 1, 1, 'a', 0)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(v1, castagnoli))
+	v1 = append(v1, sum[:]...)
+	tr := New()
+	if _, err := tr.ReadFrom(bytes.NewReader(v1)); err != nil {
+		t.Fatalf("read version 1: %v", err)
+	}
+	if v, ok := tr.Get([]byte("a")); !ok || v == nil || len(v) != 0 {
+		t.Fatalf("version 1 empty value read back as %#v, %v", v, ok)
+	}
+}
+
+// plainReader hides any io.ByteReader implementation of its reader.
+type plainReader struct{ r io.Reader }
+
+func (p plainReader) Read(b []byte) (int, error) { return p.r.Read(b) }
+
+func TestSnapshotPlainReader(t *testing.T) {
+	keys, values := rang(1000)
+	src := New()
+	for i := range keys {
+		src.ReplaceOrInsert(keys[i], values[i])
+	}
+	var buf bytes.Buffer
+	src.WriteTo(&buf)
+	dst := New()
+	if _, err := dst.ReadFrom(plainReader{&buf}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := all(dst); !reflect.DeepEqual(got, keys) {
+		t.Fatalf("loaded tree differs")
+	}
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	keys, values := rang(100)
+	src := New()
+	for i := range keys {
+		src.ReplaceOrInsert(keys[i], values[i])
+	}
+	var buf bytes.Buffer
+	src.WriteTo(&buf)
+	good := buf.Bytes()
+
+	outOfOrder := New()
+	outOfOrder.ReplaceOrInsert([]byte("a"), nil)
+	outOfOrder.ReplaceOrInsert([]byte("b"), nil)
+	var reversed bytes.Buffer
+	outOfOrder.WriteTo(&reversed)
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		tree *BTree
+		err  error
+	}{
+		{"empty", nil, New(), ErrBadSnapshot},
+		{"magic", append([]byte("xxxx"), good[4:]...), New(), ErrBadSnapshot},
+		{"version", append(append([]byte(nil), good[:4]...), append([]byte{99}, good[5:]...)...), New(), ErrBadSnapshot},
+		{"truncated", good[:len(good)/2], New(), ErrBadSnapshot},
+		{"no checksum", good[:len(good)-4], New(), ErrBadSnapshot},
+		{"flipped bit", flip(good, len(good)/2), New(), ErrBadSnapshot},
+		{"order", reversed.Bytes(), NewWithOptions(Options{Compare: reverseCompare}), ErrUnsorted},
+	} {
+		tc.tree.ReplaceOrInsert([]byte("keep"), nil)
+		if _, err := tc.tree.ReadFrom(bytes.NewReader(tc.data)); !errors.Is(err, tc.err) {
+			t.Fatalf("%s: got error %v, want %v", tc.name, err, tc.err)
+		}
+		if got, _ := all(tc.tree); len(got) != 1 || string(got[0]) != "keep" {
+			t.Fatalf("%s: failed load modified the tree: %q", tc.name, got)
+		}
+	}
+}
+
+func flip(b []byte, i int) []byte {
+	b = append([]byte(nil), b...)
+	b[i] ^= 1
+	return b
+}
+
+func BenchmarkSnapshot(b *testing.B) {
+	keys, values := rang(1_000_000)
+	tr := New()
+	tr.BulkLoad(sliceIter(keys, values))
+	var buf bytes.Buffer
+	tr.WriteTo(&buf)
+	data := buf.Bytes()
+	b.Run("WriteTo", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tr.WriteTo(io.Discard)
+		}
+	})
+	b.Run("ReadFrom", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			New().ReadFrom(bytes.NewReader(data))
+		}
+	})
+}
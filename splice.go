@@ -0,0 +1,282 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+// splicer cuts subtrees apart and glues them back together.
+//
+// A subtree is passed around as its root node and its height, leaves being at
+// height 0; an empty subtree is a nil root.  The root of a subtree may hold
+// any positive number of items, but every other node in it must respect the
+// usual bounds.  Only the nodes along the cut or glued edges are touched, and
+// they are made mutable for cow before being modified, so whole subtrees are
+// shared with the inputs.
+type splicer struct {
+	cow      *copyOnWriteContext
+	maxItems int
+	minItems int
+}
+
+func (t *BTree) splicer() splicer {
+	return splicer{cow: t.cow, maxItems: t.maxItems(), minItems: t.minItems()}
+}
+
+// height returns the height of the subtree rooted at n.
+func height(n *node) int {
+	h := 0
+	for len(n.children) > 0 {
+		n = n.children[0]
+		h++
+	}
+	return h
+}
+
+// insert adds item to the subtree rooted at n, which must not already hold
+// an equivalent item.
+func (s splicer) insert(n *node, h int, item Item) (*node, int) {
+	n = n.mutableFor(s.cow)
+	if len(n.items) >= s.maxItems {
+		item2, second := n.split(s.maxItems / 2)
+		root := s.cow.newNode()
+		root.items = append(root.items, item2)
+		root.children = append(root.children, n, second)
+		root.count = n.count + 1 + second.count
+		n, h = root, h+1
+	}
+	n.insert(item, s.maxItems, nil, 0)
+	return n, h
+}
+
+// join returns the subtree holding the items of l, then sep, then the items
+// of r.  Every item of l must sort before sep, and every item of r after it.
+func (s splicer) join(l *node, hl int, sep Item, r *node, hr int) (*node, int) {
+	sep = s.cow.detach(sep)
+	switch {
+	case l == nil && r == nil:
+		n := s.cow.newNode()
+		n.items = append(n.items, sep)
+		n.count = 1
+		return n, 0
+	case l == nil:
+		return s.insert(r, hr, sep)
+	case r == nil:
+		return s.insert(l, hl, sep)
+	case hl > hr:
+		return s.graft(l, hl, sep, r, hr, false)
+	case hl < hr:
+		return s.graft(r, hr, sep, l, hl, true)
+	}
+	if len(l.items)+1+len(r.items) <= s.maxItems {
+		n := l.mutableFor(s.cow)
+		n.open()
+		n.items = append(n.items, sep)
+		n.items = append(n.items, r.fullItems()...)
+		n.children = append(n.children, r.children...)
+		n.count += 1 + r.count
+		s.cow.freeNode(r)
+		return n, hl
+	}
+	n := s.cow.newNode()
+	n.items = append(n.items, sep)
+	n.children = append(n.children, l, r)
+	if len(l.items) < s.minItems || len(r.items) < s.minItems {
+		n.mutableChild(0)
+		n.mutableChild(1)
+		n.balanceChildren(0)
+	}
+	n.recount()
+	return n, hl + 1
+}
+
+// graft hangs the shorter subtree small off the right edge of big (or the
+// left edge, if front is set), with sep between the two, and splits whatever
+// nodes overflow on the way back up.
+func (s splicer) graft(big *node, hb int, sep Item, small *node, hs int, front bool) (*node, int) {
+	edge := func(n *node) int {
+		if front {
+			return 0
+		}
+		return len(n.children) - 1
+	}
+	root := big.mutableFor(s.cow)
+	path := make([]*node, 0, hb-hs)
+	n := root
+	for h := hb; ; h-- {
+		n.count += 1 + small.count
+		path = append(path, n)
+		if h == hs+1 {
+			break
+		}
+		n = n.mutableChild(edge(n))
+	}
+	if front {
+		n.items.insertAt(0, sep)
+		n.children.insertAt(0, small)
+	} else {
+		n.items = append(n.items, sep)
+		n.children = append(n.children, small)
+	}
+	if len(small.items) < s.minItems {
+		if front {
+			s.fixPair(n, 0)
+		} else {
+			s.fixPair(n, len(n.items)-1)
+		}
+	}
+	for k := len(path) - 1; k >= 0 && len(path[k].items) > s.maxItems; k-- {
+		n := path[k]
+		item, next := n.split(len(n.items) / 2)
+		if k == 0 {
+			root = s.cow.newNode()
+			root.items = append(root.items, item)
+			root.children = append(root.children, n, next)
+			root.count = n.count + 1 + next.count
+			hb++
+			break
+		}
+		parent := path[k-1]
+		i := edge(parent)
+		parent.items.insertAt(i, item)
+		parent.children.insertAt(i+1, next)
+	}
+	return root, hb
+}
+
+// fixPair repairs n.children[i] and n.children[i+1] when one of them holds
+// too few items, either by merging them around n.items[i] or by sharing their
+// items evenly.
+func (s splicer) fixPair(n *node, i int) {
+	left, right := n.mutableChild(i), n.children[i+1]
+	if len(left.items)+1+len(right.items) <= s.maxItems {
+		left.open()
+		left.items = append(left.items, n.items.removeAt(i))
+		left.items = append(left.items, right.fullItems()...)
+		left.children = append(left.children, right.children...)
+		left.count += 1 + right.count
+		n.children.removeAt(i + 1)
+		s.cow.freeNode(right)
+		return
+	}
+	n.mutableChild(i + 1)
+	n.balanceChildren(i)
+}
+
+// concat returns the subtree holding the items of l followed by the items of
+// r.  Every item of l must sort before every item of r.
+func (s splicer) concat(l *node, hl int, r *node, hr int) (*node, int) {
+	if l == nil {
+		return r, hr
+	}
+	if r == nil {
+		return l, hl
+	}
+	sep, r, hr := s.popMin(r, hr)
+	return s.join(l, hl, sep, r, hr)
+}
+
+// popMin removes the smallest item from the subtree rooted at n, of height h,
+// and returns it along with what is left of the subtree.
+func (s splicer) popMin(n *node, h int) (Item, *node, int) {
+	n = n.mutableFor(s.cow)
+	item, _ := n.remove(nil, s.minItems, removeMin, nil, 0)
+	if len(n.items) == 0 {
+		old := n
+		n, h = nil, h-1
+		if len(old.children) > 0 {
+			n = old.children[0]
+		}
+		s.cow.freeNode(old)
+	}
+	return item, n, h
+}
+
+// split divides the subtree rooted at n, of height h, into the items that
+// sort before key and the items that don't.
+func (s splicer) split(n *node, h int, key *Item) (l *node, hl int, r *node, hr int) {
+	i, found := n.find(key, nil, 0)
+	if len(n.children) == 0 {
+		switch i {
+		case 0:
+			return nil, 0, n, 0
+		case len(n.items):
+			return n, 0, nil, 0
+		}
+		r = s.cow.newNode()
+		r.items = append(r.items, n.fullItems()[i:]...)
+		r.count = len(r.items)
+		l = n.mutableFor(s.cow)
+		l.items.truncate(i)
+		l.count = i
+		return l, 0, r, 0
+	}
+	if found {
+		r, hr = s.suffix(n, h, i+1)
+		r, hr = s.join(nil, 0, n.item(i), r, hr)
+		l, hl = s.prefix(n, h, i)
+		return l, hl, r, hr
+	}
+	cl, hcl, cr, hcr := s.split(n.children[i], h-1, key)
+	r, hr = cr, hcr
+	if i < len(n.items) {
+		suffix, hs := s.suffix(n, h, i+1)
+		r, hr = s.join(cr, hcr, n.item(i), suffix, hs)
+	}
+	l, hl = cl, hcl
+	if i > 0 {
+		sep := n.item(i - 1)
+		prefix, hp := s.prefix(n, h, i-1)
+		l, hl = s.join(prefix, hp, sep, cl, hcl)
+	}
+	return l, hl, r, hr
+}
+
+// splitBefore is split, without touching n when all of it falls on one side
+// of key.
+func (s splicer) splitBefore(n *node, h int, key *Item) (l *node, hl int, r *node, hr int) {
+	switch {
+	case n == nil:
+		return nil, 0, nil, 0
+	case s.cow.compare(max(n)[0], (*key)[0]) < 0:
+		return n, h, nil, 0
+	case s.cow.compare(min(n)[0], (*key)[0]) >= 0:
+		return nil, 0, n, h
+	}
+	return s.split(n, h, key)
+}
+
+// prefix returns the subtree made of n.items[:j] and n.children[:j+1], where
+// n has height h.  It may reuse n, so it must be called after suffix.
+func (s splicer) prefix(n *node, h, j int) (*node, int) {
+	if j == 0 {
+		return n.children[0], h - 1
+	}
+	p := n.mutableFor(s.cow)
+	p.items.truncate(j)
+	p.children.truncate(j + 1)
+	p.recount()
+	return p, h
+}
+
+// suffix returns the subtree made of n.items[j:] and n.children[j:], where n
+// has height h.
+func (s splicer) suffix(n *node, h, j int) (*node, int) {
+	if j == len(n.items) {
+		return n.children[j], h - 1
+	}
+	p := s.cow.newNode()
+	p.items = append(p.items, n.fullItems()[j:]...)
+	p.children = append(p.children, n.children[j:]...)
+	p.recount()
+	return p, h
+}
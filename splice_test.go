@@ -0,0 +1,90 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// seqTree returns a tree of the given degree holding seqKey(i) for i in
+// [from, to).
+func seqTree(degree, from, to int) *BTree {
+	tr := NewWithOptions(Options{Degree: degree})
+	for i := from; i < to; i++ {
+		tr.ReplaceOrInsert(seqKey(i), nil)
+	}
+	return tr
+}
+
+// checkSeq verifies that tr is a valid tree holding exactly seqKey(i) for i
+// in [from, to).
+func checkSeq(t *testing.T, tr *BTree, from, to int) {
+	t.Helper()
+	checkTree(t, tr)
+	i := from
+	tr.Ascend(func(k, v []byte) bool {
+		if !bytes.Equal(k, seqKey(i)) {
+			t.Fatalf("got key %x, want %x", k, seqKey(i))
+		}
+		i++
+		return true
+	})
+	if i != to {
+		t.Fatalf("tree ends at %d, want %d", i, to)
+	}
+}
+
+func TestSplicerConcat(t *testing.T) {
+	for _, degree := range []int{2, 3, 5} {
+		for iter := 0; iter < 200; iter++ {
+			mid := rand.Intn(400)
+			end := mid + rand.Intn(400)
+			l, r := seqTree(degree, 0, mid), seqTree(degree, mid, end)
+			// r's nodes end up in l: r is discarded afterwards, so sharing its
+			// nodes is fine.
+			s := l.splicer()
+			var hl, hr int
+			if l.root != nil {
+				hl = height(l.root)
+			}
+			if r.root != nil {
+				hr = height(r.root)
+			}
+			l.root, _ = s.concat(l.root, hl, r.root, hr)
+			l.length = end
+			checkSeq(t, l, 0, end)
+		}
+	}
+}
+
+func TestSplicerSplit(t *testing.T) {
+	for _, degree := range []int{2, 3, 5} {
+		for iter := 0; iter < 200; iter++ {
+			size := 1 + rand.Intn(500)
+			at := rand.Intn(size + 1)
+			tr := seqTree(degree, 0, size)
+			snapshot := tr.Clone()
+			s := tr.splicer()
+			l, _, r, _ := s.split(tr.root, height(tr.root), &Item{seqKey(at)})
+			left := &BTree{degree: degree, root: l, cow: tr.cow, length: at}
+			right := &BTree{degree: degree, root: r, cow: tr.cow, length: size - at}
+			checkSeq(t, left, 0, at)
+			checkSeq(t, right, at, size)
+			checkSeq(t, snapshot, 0, size)
+		}
+	}
+}
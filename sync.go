@@ -0,0 +1,345 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"io"
+	"sync"
+)
+
+// SyncBTree is a BTree that is safe for concurrent use by multiple goroutines.
+//
+// Reads hold a read lock and writes hold the write lock, for the duration of
+// the call.  The iterator passed to Ascend* and Descend* runs with the read
+// lock held, so it must not write to the tree; use View to walk a snapshot
+// without holding up writers, and Update to run several writes under a single
+// lock.
+type SyncBTree struct {
+	mu sync.RWMutex
+	t  *BTree
+}
+
+// NewSync returns a SyncBTree that guards t.  t must not be used directly
+// afterwards.
+func NewSync(t *BTree) *SyncBTree {
+	return &SyncBTree{t: t}
+}
+
+// Update calls fn with the tree while holding the write lock, so that no
+// other call observes the tree partway through the writes fn makes.  fn must
+// not retain the tree, nor call methods of s.
+func (s *SyncBTree) Update(fn func(t *BTree)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.t)
+}
+
+// View calls fn with a Clone of the tree.  The lock is only held while the
+// clone is taken, so fn may take as long as it needs, and may keep the clone,
+// without blocking writers.
+func (s *SyncBTree) View(fn func(t *BTree)) {
+	fn(s.clone())
+}
+
+// Snapshot is BTree.Snapshot.  The lock is only held while the snapshot is
+// taken.
+func (s *SyncBTree) Snapshot() *ReadOnlyBTree {
+	// Snapshot gives the tree it is called on a new write context, so it
+	// needs the write lock.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Snapshot()
+}
+
+// Clone returns a SyncBTree that guards a Clone of the tree.
+func (s *SyncBTree) Clone() *SyncBTree {
+	return NewSync(s.clone())
+}
+
+// clone returns a Clone of the tree.
+func (s *SyncBTree) clone() *BTree {
+	// Clone modifies the tree it is called on, so it needs the write lock.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Clone()
+}
+
+// ReplaceOrInsert is BTree.ReplaceOrInsert, under the write lock.
+func (s *SyncBTree) ReplaceOrInsert(k, v []byte) ([]byte, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.ReplaceOrInsert(k, v)
+}
+
+// Upsert is BTree.Upsert, under the write lock.  fn is called with the lock
+// held.
+func (s *SyncBTree) Upsert(k []byte, fn UpsertFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.Upsert(k, fn)
+}
+
+// Merge is BTree.Merge, under the write lock.
+func (s *SyncBTree) Merge(k, operand []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.Merge(k, operand)
+}
+
+// SetMergeOperator is BTree.SetMergeOperator, under the write lock.
+func (s *SyncBTree) SetMergeOperator(fn MergeFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.SetMergeOperator(fn)
+}
+
+// InsertIfAbsent is BTree.InsertIfAbsent, under the write lock.
+func (s *SyncBTree) InsertIfAbsent(k, v []byte) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.InsertIfAbsent(k, v)
+}
+
+// CompareAndSwap is BTree.CompareAndSwap, under the write lock.
+func (s *SyncBTree) CompareAndSwap(k, old, newV []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.CompareAndSwap(k, old, newV)
+}
+
+// CompareAndDelete is BTree.CompareAndDelete, under the write lock.
+func (s *SyncBTree) CompareAndDelete(k, old []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.CompareAndDelete(k, old)
+}
+
+// Delete is BTree.Delete, under the write lock.
+func (s *SyncBTree) Delete(k []byte) ([]byte, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.Delete(k)
+}
+
+// DeleteMin is BTree.DeleteMin, under the write lock.
+func (s *SyncBTree) DeleteMin() ([]byte, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.DeleteMin()
+}
+
+// DeleteMax is BTree.DeleteMax, under the write lock.
+func (s *SyncBTree) DeleteMax() ([]byte, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.DeleteMax()
+}
+
+// DeleteAt is BTree.DeleteAt, under the write lock.
+func (s *SyncBTree) DeleteAt(i int) ([]byte, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.DeleteAt(i)
+}
+
+// DeleteRange is BTree.DeleteRange, under the write lock.
+func (s *SyncBTree) DeleteRange(greaterOrEqual, lessThan []byte) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.DeleteRange(greaterOrEqual, lessThan)
+}
+
+// DeletePrefix is BTree.DeletePrefix, under the write lock.
+func (s *SyncBTree) DeletePrefix(prefix []byte) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.DeletePrefix(prefix)
+}
+
+// Clear is BTree.Clear, under the write lock.
+func (s *SyncBTree) Clear(addNodesToFreelist bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.Clear(addNodesToFreelist)
+}
+
+// BulkLoad is BTree.BulkLoad, under the write lock.  iter is called with the
+// lock held.
+func (s *SyncBTree) BulkLoad(iter func() (k, v []byte, ok bool)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.BulkLoad(iter)
+}
+
+// ReadFrom is BTree.ReadFrom, under the write lock.
+func (s *SyncBTree) ReadFrom(r io.Reader) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.ReadFrom(r)
+}
+
+// WriteTo is BTree.WriteTo, under the read lock.
+func (s *SyncBTree) WriteTo(w io.Writer) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.WriteTo(w)
+}
+
+// Get is BTree.Get, under the read lock.
+func (s *SyncBTree) Get(key []byte) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Get(key)
+}
+
+// GetCopy is BTree.GetCopy, under the read lock.
+func (s *SyncBTree) GetCopy(key []byte) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.GetCopy(key)
+}
+
+// AppendValue is BTree.AppendValue, under the read lock.
+func (s *SyncBTree) AppendValue(dst, key []byte) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.AppendValue(dst, key)
+}
+
+// Has is BTree.Has, under the read lock.
+func (s *SyncBTree) Has(key []byte) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Has(key)
+}
+
+// Min is BTree.Min, under the read lock.
+func (s *SyncBTree) Min() ([]byte, []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Min()
+}
+
+// Max is BTree.Max, under the read lock.
+func (s *SyncBTree) Max() ([]byte, []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Max()
+}
+
+// GetAt is BTree.GetAt, under the read lock.
+func (s *SyncBTree) GetAt(i int) ([]byte, []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.GetAt(i)
+}
+
+// Rank is BTree.Rank, under the read lock.
+func (s *SyncBTree) Rank(key []byte) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Rank(key)
+}
+
+// CountRange is BTree.CountRange, under the read lock.
+func (s *SyncBTree) CountRange(greaterOrEqual, lessThan []byte) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.CountRange(greaterOrEqual, lessThan)
+}
+
+// CountPrefix is BTree.CountPrefix, under the read lock.
+func (s *SyncBTree) CountPrefix(prefix []byte) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.CountPrefix(prefix)
+}
+
+// Len is BTree.Len, under the read lock.
+func (s *SyncBTree) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Len()
+}
+
+// Ascend is BTree.Ascend, under the read lock.
+func (s *SyncBTree) Ascend(iterator ItemIterator) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.t.Ascend(iterator)
+}
+
+// AscendRange is BTree.AscendRange, under the read lock.
+func (s *SyncBTree) AscendRange(greaterOrEqual, lessThan []byte, iterator ItemIterator) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.t.AscendRange(greaterOrEqual, lessThan, iterator)
+}
+
+// AscendLessThan is BTree.AscendLessThan, under the read lock.
+func (s *SyncBTree) AscendLessThan(pivot []byte, iterator ItemIterator) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.t.AscendLessThan(pivot, iterator)
+}
+
+// AscendGreaterOrEqual is BTree.AscendGreaterOrEqual, under the read lock.
+func (s *SyncBTree) AscendGreaterOrEqual(pivot []byte, iterator ItemIterator) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.t.AscendGreaterOrEqual(pivot, iterator)
+}
+
+// AscendPrefix is BTree.AscendPrefix, under the read lock.
+func (s *SyncBTree) AscendPrefix(prefix []byte, iterator ItemIterator) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.t.AscendPrefix(prefix, iterator)
+}
+
+// Descend is BTree.Descend, under the read lock.
+func (s *SyncBTree) Descend(iterator ItemIterator) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.t.Descend(iterator)
+}
+
+// DescendRange is BTree.DescendRange, under the read lock.
+func (s *SyncBTree) DescendRange(lessOrEqual, greaterThan []byte, iterator ItemIterator) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.t.DescendRange(lessOrEqual, greaterThan, iterator)
+}
+
+// DescendLessOrEqual is BTree.DescendLessOrEqual, under the read lock.
+func (s *SyncBTree) DescendLessOrEqual(pivot []byte, iterator ItemIterator) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.t.DescendLessOrEqual(pivot, iterator)
+}
+
+// DescendGreaterThan is BTree.DescendGreaterThan, under the read lock.
+func (s *SyncBTree) DescendGreaterThan(pivot []byte, iterator ItemIterator) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.t.DescendGreaterThan(pivot, iterator)
+}
+
+// DescendPrefix is BTree.DescendPrefix, under the read lock.
+func (s *SyncBTree) DescendPrefix(prefix []byte, iterator ItemIterator) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.t.DescendPrefix(prefix, iterator)
+}
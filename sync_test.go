@@ -0,0 +1,88 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSyncBTree(t *testing.T) {
+	const writers, perWriter = 4, 500
+	s := NewSync(NewWithOptions(Options{Degree: 3}))
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w * perWriter; i < (w+1)*perWriter; i++ {
+				// Each pair of keys is written together, so readers must
+				// never see one without the other.
+				s.Update(func(t *BTree) {
+					t.ReplaceOrInsert(seqKey(2*i), nil)
+					t.ReplaceOrInsert(seqKey(2*i+1), nil)
+				})
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if n := s.Len(); n%2 != 0 {
+					t.Errorf("Len = %d, saw half of an update", n)
+					return
+				}
+				s.View(func(tr *BTree) {
+					n := 0
+					tr.Ascend(func(k, v []byte) bool {
+						n++
+						return true
+					})
+					if n != tr.Len() || n%2 != 0 {
+						t.Errorf("snapshot of %d items holds %d", tr.Len(), n)
+					}
+				})
+				s.Get(seqKey(0))
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	readers.Wait()
+	s.View(func(tr *BTree) { checkSeq(t, tr, 0, 2*writers*perWriter) })
+}
+
+func TestSyncBTreeSnapshot(t *testing.T) {
+	s := NewSync(New())
+	s.ReplaceOrInsert([]byte("a"), []byte("1"))
+	snap := s.Snapshot()
+	s.ReplaceOrInsert([]byte("a"), []byte("2"))
+	s.ReplaceOrInsert([]byte("b"), []byte("2"))
+	if v, _ := snap.Get([]byte("a")); string(v) != "1" || snap.Len() != 1 {
+		t.Fatalf("snapshot changed by later writes: a = %q, len %d", v, snap.Len())
+	}
+	if v, _ := s.Get([]byte("a")); string(v) != "2" || s.Len() != 2 {
+		t.Fatalf("tree after writes: a = %q, len %d", v, s.Len())
+	}
+}
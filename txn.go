@@ -0,0 +1,227 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import "errors"
+
+var (
+	// ErrBaseChanged is returned by Txn.Commit when the tree the transaction
+	// began on was written to, e.g. by another transaction's commit, since.
+	ErrBaseChanged = errors.New("bytebtree: tree modified since the transaction began")
+	// ErrTxnDone is returned by Txn.Commit when the transaction has already
+	// been committed or rolled back.
+	ErrTxnDone = errors.New("bytebtree: transaction already committed or rolled back")
+)
+
+// Txn is a write transaction on a BTree, started by Begin.
+//
+// A Txn works on a Clone of its tree, and offers the read and write methods
+// of a BTree: its writes are invisible to the tree until Commit applies all of
+// them at once, and Rollback discards them.  It has none of the methods through
+// which another tree could share its nodes, such as Clone or SplitAt, and
+// isn't a BTree to pass to Union or Diff, since Rollback and RollbackTo return
+// the nodes the transaction wrote to the free list.  A Txn can't be used after
+// Commit or Rollback.
+type Txn struct {
+	t          *BTree
+	parent     *BTree
+	base       *node
+	savepoints []savepoint
+	lastID     SavepointID
+}
+
+// Begin starts a write transaction on t.
+//
+// t stays usable while the transaction runs, but any write to it makes the
+// transaction fail to commit.  Begin should not be called concurrently with
+// other calls on t.
+func (t *BTree) Begin() *Txn {
+	return &Txn{t: t.Clone(), parent: t, base: t.root}
+}
+
+// Commit makes the writes of the transaction visible in the tree it began on,
+// and ends the transaction.  It fails with ErrBaseChanged, leaving the tree
+// untouched and rolling the transaction back, if the tree was written to
+// since Begin: this happens in particular when two transactions begun on the
+// same tree are both committed, which only the first one does.  Savepoints
+// still held are released.
+func (txn *Txn) Commit() error {
+	if txn.t == nil {
+		return ErrTxnDone
+	}
+	if txn.parent.root != txn.base {
+		txn.Rollback()
+		return ErrBaseChanged
+	}
+	if len(txn.savepoints) > 0 {
+		txn.release(0)
+	}
+	// The tree takes over the transaction's write context along with its
+	// root, so that it owns, and can keep writing in place, every node the
+	// transaction created.
+	p := txn.parent
+	p.root, p.length, p.cow = txn.t.root, txn.t.length, txn.t.cow
+	txn.t = nil
+	return nil
+}
+
+// Rollback discards the writes of the transaction and ends it.  The nodes the
+// transaction created are returned to the free list.  Rollback does nothing if
+// the transaction is already over.
+func (txn *Txn) Rollback() {
+	if txn.t == nil {
+		return
+	}
+	if len(txn.savepoints) > 0 {
+		txn.rollbackTo(0)
+	}
+	if txn.t.root != nil {
+		txn.t.cow.freeOwned(txn.t.root)
+	}
+	txn.t = nil
+}
+
+// freeOwned frees every node of the subtree rooted at n that c owns.  Nodes
+// are only ever made mutable below a mutable parent, so the search stops at
+// the first node c doesn't own.
+func (c *copyOnWriteContext) freeOwned(n *node) {
+	if n.cow != c {
+		return
+	}
+	for _, child := range n.children {
+		c.freeOwned(child)
+	}
+	c.freeNode(n)
+}
+
+// Get is BTree.Get.
+func (txn *Txn) Get(key []byte) ([]byte, bool) { return txn.t.Get(key) }
+
+// Has is BTree.Has.
+func (txn *Txn) Has(key []byte) bool { return txn.t.Has(key) }
+
+// Min is BTree.Min.
+func (txn *Txn) Min() ([]byte, []byte) { return txn.t.Min() }
+
+// Max is BTree.Max.
+func (txn *Txn) Max() ([]byte, []byte) { return txn.t.Max() }
+
+// Len is BTree.Len.
+func (txn *Txn) Len() int { return txn.t.Len() }
+
+// GetAt is BTree.GetAt.
+func (txn *Txn) GetAt(i int) ([]byte, []byte) { return txn.t.GetAt(i) }
+
+// Rank is BTree.Rank.
+func (txn *Txn) Rank(key []byte) int { return txn.t.Rank(key) }
+
+// CountRange is BTree.CountRange.
+func (txn *Txn) CountRange(greaterOrEqual, lessThan []byte) int {
+	return txn.t.CountRange(greaterOrEqual, lessThan)
+}
+
+// CountPrefix is BTree.CountPrefix.
+func (txn *Txn) CountPrefix(prefix []byte) int { return txn.t.CountPrefix(prefix) }
+
+// Ascend is BTree.Ascend.
+func (txn *Txn) Ascend(iterator ItemIterator) { txn.t.Ascend(iterator) }
+
+// AscendRange is BTree.AscendRange.
+func (txn *Txn) AscendRange(greaterOrEqual, lessThan []byte, iterator ItemIterator) {
+	txn.t.AscendRange(greaterOrEqual, lessThan, iterator)
+}
+
+// AscendLessThan is BTree.AscendLessThan.
+func (txn *Txn) AscendLessThan(pivot []byte, iterator ItemIterator) {
+	txn.t.AscendLessThan(pivot, iterator)
+}
+
+// AscendGreaterOrEqual is BTree.AscendGreaterOrEqual.
+func (txn *Txn) AscendGreaterOrEqual(pivot []byte, iterator ItemIterator) {
+	txn.t.AscendGreaterOrEqual(pivot, iterator)
+}
+
+// AscendPrefix is BTree.AscendPrefix.
+func (txn *Txn) AscendPrefix(prefix []byte, iterator ItemIterator) {
+	txn.t.AscendPrefix(prefix, iterator)
+}
+
+// Descend is BTree.Descend.
+func (txn *Txn) Descend(iterator ItemIterator) { txn.t.Descend(iterator) }
+
+// DescendRange is BTree.DescendRange.
+func (txn *Txn) DescendRange(lessOrEqual, greaterThan []byte, iterator ItemIterator) {
+	txn.t.DescendRange(lessOrEqual, greaterThan, iterator)
+}
+
+// DescendLessOrEqual is BTree.DescendLessOrEqual.
+func (txn *Txn) DescendLessOrEqual(pivot []byte, iterator ItemIterator) {
+	txn.t.DescendLessOrEqual(pivot, iterator)
+}
+
+// DescendGreaterThan is BTree.DescendGreaterThan.
+func (txn *Txn) DescendGreaterThan(pivot []byte, iterator ItemIterator) {
+	txn.t.DescendGreaterThan(pivot, iterator)
+}
+
+// DescendPrefix is BTree.DescendPrefix.
+func (txn *Txn) DescendPrefix(prefix []byte, iterator ItemIterator) {
+	txn.t.DescendPrefix(prefix, iterator)
+}
+
+// ReplaceOrInsert is BTree.ReplaceOrInsert.
+func (txn *Txn) ReplaceOrInsert(k, v []byte) ([]byte, []byte) { return txn.t.ReplaceOrInsert(k, v) }
+
+// Delete is BTree.Delete.
+func (txn *Txn) Delete(k []byte) ([]byte, []byte) { return txn.t.Delete(k) }
+
+// DeleteMin is BTree.DeleteMin.
+func (txn *Txn) DeleteMin() ([]byte, []byte) { return txn.t.DeleteMin() }
+
+// DeleteMax is BTree.DeleteMax.
+func (txn *Txn) DeleteMax() ([]byte, []byte) { return txn.t.DeleteMax() }
+
+// DeleteAt is BTree.DeleteAt.
+func (txn *Txn) DeleteAt(i int) ([]byte, []byte) { return txn.t.DeleteAt(i) }
+
+// DeleteRange is BTree.DeleteRange.
+func (txn *Txn) DeleteRange(greaterOrEqual, lessThan []byte) int {
+	return txn.t.DeleteRange(greaterOrEqual, lessThan)
+}
+
+// DeletePrefix is BTree.DeletePrefix.
+func (txn *Txn) DeletePrefix(prefix []byte) int { return txn.t.DeletePrefix(prefix) }
+
+// Upsert is BTree.Upsert.
+func (txn *Txn) Upsert(k []byte, fn UpsertFunc) { txn.t.Upsert(k, fn) }
+
+// InsertIfAbsent is BTree.InsertIfAbsent.
+func (txn *Txn) InsertIfAbsent(k, v []byte) (existing []byte, inserted bool) {
+	return txn.t.InsertIfAbsent(k, v)
+}
+
+// CompareAndSwap is BTree.CompareAndSwap.
+func (txn *Txn) CompareAndSwap(k, old, newV []byte) (swapped bool) {
+	return txn.t.CompareAndSwap(k, old, newV)
+}
+
+// CompareAndDelete is BTree.CompareAndDelete.
+func (txn *Txn) CompareAndDelete(k, old []byte) (deleted bool) {
+	return txn.t.CompareAndDelete(k, old)
+}
+
+// Merge is BTree.Merge, with the merge operator of the tree the transaction
+// began on.
+func (txn *Txn) Merge(k, operand []byte) { txn.t.Merge(k, operand) }
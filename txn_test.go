@@ -0,0 +1,92 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTxnCommit(t *testing.T) {
+	tr := seqTree(3, 0, 100)
+	txn := tr.Begin()
+	for i := 0; i < 50; i++ {
+		txn.Delete(seqKey(i))
+	}
+	for i := 100; i < 150; i++ {
+		txn.ReplaceOrInsert(seqKey(i), nil)
+	}
+	checkSeq(t, txn.t, 50, 150)
+	checkSeq(t, tr, 0, 100)
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	checkSeq(t, tr, 50, 150)
+	if err := txn.Commit(); !errors.Is(err, ErrTxnDone) {
+		t.Fatalf("second Commit: got %v, want %v", err, ErrTxnDone)
+	}
+
+	// The tree owns the nodes written by the transaction, and keeps
+	// writing them in place.
+	root := tr.root
+	tr.ReplaceOrInsert(seqKey(150), nil)
+	if tr.root != root {
+		t.Fatalf("write after commit copied the root")
+	}
+	checkSeq(t, tr, 50, 151)
+}
+
+func TestTxnRollback(t *testing.T) {
+	fl := NewFreeList(1000)
+	tr := NewWithOptions(Options{Degree: 3, FreeList: fl})
+	for i := 0; i < 100; i++ {
+		tr.ReplaceOrInsert(seqKey(i), nil)
+	}
+	free := len(fl.freelist)
+	txn := tr.Begin()
+	for i := 0; i < 100; i += 3 {
+		txn.Delete(seqKey(i))
+	}
+	txn.Rollback()
+	if got := len(fl.freelist); got <= free {
+		t.Fatalf("free list holds %d nodes after rollback, %d before the transaction", got, free)
+	}
+	checkSeq(t, tr, 0, 100)
+	if err := txn.Commit(); !errors.Is(err, ErrTxnDone) {
+		t.Fatalf("Commit after Rollback: got %v, want %v", err, ErrTxnDone)
+	}
+}
+
+func TestTxnBaseChanged(t *testing.T) {
+	tr := seqTree(3, 0, 10)
+	txn1, txn2 := tr.Begin(), tr.Begin()
+	txn1.ReplaceOrInsert(seqKey(10), nil)
+	txn2.ReplaceOrInsert(seqKey(11), nil)
+	if err := txn1.Commit(); err != nil {
+		t.Fatalf("first Commit: %v", err)
+	}
+	if err := txn2.Commit(); !errors.Is(err, ErrBaseChanged) {
+		t.Fatalf("second Commit: got %v, want %v", err, ErrBaseChanged)
+	}
+	checkSeq(t, tr, 0, 11)
+
+	txn := tr.Begin()
+	txn.Delete(seqKey(0))
+	tr.ReplaceOrInsert(seqKey(11), nil)
+	if err := txn.Commit(); !errors.Is(err, ErrBaseChanged) {
+		t.Fatalf("Commit after a direct write: got %v, want %v", err, ErrBaseChanged)
+	}
+	checkSeq(t, tr, 0, 12)
+}
@@ -0,0 +1,236 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import "bytes"
+
+// UpsertFunc computes the new value for a key from its current one.  exists
+// reports whether the key is in the tree, old being nil if it isn't.  The key
+// ends up holding newV if keep is true, and is removed from the tree (or left
+// out of it) otherwise.
+type UpsertFunc func(old []byte, exists bool) (newV []byte, keep bool)
+
+// MergeFunc combines operand, passed to Merge, with the current value of key,
+// and returns the new value.  exists reports whether key is in the tree.
+type MergeFunc func(key, old []byte, exists bool, operand []byte) []byte
+
+// Upsert looks up k and calls fn with its value, then inserts, updates or
+// deletes k according to fn's answer, all in a single descent of the tree.
+//
+// Insert and Delete search the tree knowing whether it grows or shrinks, and
+// split or merge nodes on their way down.  Upsert only learns that at the
+// bottom, so it splits or merges nodes on its way back up instead.  fn must
+// not use the tree.
+func (t *BTree) Upsert(k []byte, fn UpsertFunc) {
+	t.upsert(k, func(old []byte, exists bool) ([]byte, upsertAction) {
+		v, keep := fn(old, exists)
+		switch {
+		case keep:
+			return v, upsertPut
+		case exists:
+			return nil, upsertDelete
+		}
+		return nil, upsertNone
+	})
+}
+
+// upsertAction is what upsert does with a key.
+type upsertAction int
+
+const (
+	upsertNone upsertAction = iota
+	upsertPut
+	upsertDelete
+)
+
+// upsert looks up k and calls fn with its value, then acts on k as fn says.
+//
+// The search doesn't modify anything, and only records the index taken at
+// each level.  If fn asks for a change, the nodes along the recorded path are
+// then made mutable and modified, so a tree sharing its nodes with a clone
+// only copies them when it actually writes.
+func (t *BTree) upsert(k []byte, fn func(old []byte, exists bool) ([]byte, upsertAction)) {
+	if k == nil {
+		panic("nil item being added to BTree")
+	}
+	defer t.cow.packDirty()
+	if t.root == nil {
+		v, act := fn(nil, false)
+		if act != upsertPut {
+			return
+		}
+		k, v = t.cow.own(k, v)
+		t.root = t.cow.newNode()
+		t.root.items = append(t.root.items, Item{k, v})
+		t.root.count = 1
+		t.length++
+		return
+	}
+	var buf [16]int
+	path := buf[:0]
+	key := Item{k}
+	n := t.root
+	var old []byte
+	found := false
+	for {
+		var i int
+		i, found = n.find(&key, nil, 0)
+		path = append(path, i)
+		if found {
+			old = n.items[i][1]
+			break
+		}
+		if len(n.children) == 0 {
+			break
+		}
+		n = n.children[i]
+	}
+	v, act := fn(old, found)
+	if act == upsertNone || act == upsertDelete && !found {
+		return
+	}
+	t.root = t.root.mutableFor(t.cow)
+	t.length += t.apply(t.root, path, found, k, v, act)
+	switch root := t.root; {
+	case len(root.items) > t.maxItems():
+		item, second := root.split(t.maxItems() / 2)
+		t.root = t.cow.newNode()
+		t.root.items = append(t.root.items, item)
+		t.root.children = append(t.root.children, root, second)
+		t.root.count = root.count + 1 + second.count
+	case len(root.items) == 0 && len(root.children) > 0:
+		t.root = root.children[0]
+		t.cow.freeNode(root)
+	}
+	t.maybeCompact()
+}
+
+// apply carries out act on k in the subtree rooted at n, which must be
+// mutable, following path to k, and returns the change in the number of
+// items the subtree holds.  The last index of path is that of k itself if
+// found is set, or where k belongs in a leaf otherwise.  n may end up with
+// one item too many or too few, for its parent to fix.
+func (t *BTree) apply(n *node, path []int, found bool, k, v []byte, act upsertAction) int {
+	i := path[0]
+	if len(path) > 1 {
+		delta := t.apply(n.mutableChild(i), path[1:], found, k, v, act)
+		n.count += delta
+		t.fixChild(n, i)
+		return delta
+	}
+	if !found {
+		k, v = t.cow.own(k, v)
+		n.insertLeaf(i, Item{k, v})
+		n.count++
+		return 1
+	}
+	old := n.items[i]
+	if act == upsertPut {
+		if t.cow.arena != nil {
+			t.cow.arena.free(Item{nil, old[1]})
+		}
+		_, n.items[i][1] = t.cow.own(nil, v)
+		return 0
+	}
+	if t.cow.arena != nil {
+		t.cow.arena.free(old)
+	}
+	n.count--
+	if len(n.children) == 0 {
+		n.items.removeAt(i)
+		return -1
+	}
+	// Replace the item by its predecessor, as remove does.
+	pred, _ := n.mutableChild(i).remove(nil, t.minItems(), removeMax, nil, 0)
+	n.items[i] = t.cow.detach(pred)
+	t.fixChild(n, i)
+	return -1
+}
+
+// InsertIfAbsent adds k with value v to the tree, unless k is already in it.
+// It returns the value k had, and whether v was inserted.
+func (t *BTree) InsertIfAbsent(k, v []byte) (existing []byte, inserted bool) {
+	t.upsert(k, func(old []byte, exists bool) ([]byte, upsertAction) {
+		if exists {
+			existing = old
+			return nil, upsertNone
+		}
+		inserted = true
+		return v, upsertPut
+	})
+	return existing, inserted
+}
+
+// CompareAndSwap replaces the value of k by newV if k is in the tree with a
+// value equal to old, and reports whether it did.
+func (t *BTree) CompareAndSwap(k, old, newV []byte) (swapped bool) {
+	t.upsert(k, func(cur []byte, exists bool) ([]byte, upsertAction) {
+		if !exists || !bytes.Equal(cur, old) {
+			return nil, upsertNone
+		}
+		swapped = true
+		return newV, upsertPut
+	})
+	return swapped
+}
+
+// CompareAndDelete removes k from the tree if its value is equal to old, and
+// reports whether it did.
+func (t *BTree) CompareAndDelete(k, old []byte) (deleted bool) {
+	t.upsert(k, func(cur []byte, exists bool) ([]byte, upsertAction) {
+		if !exists || !bytes.Equal(cur, old) {
+			return nil, upsertNone
+		}
+		deleted = true
+		return nil, upsertDelete
+	})
+	return deleted
+}
+
+// fixChild splits n.children[i] if it holds too many items, or refills it
+// from a sibling if it holds too few.
+func (t *BTree) fixChild(n *node, i int) {
+	child := n.children[i]
+	switch {
+	case len(child.items) > t.maxItems():
+		item, second := child.split(t.maxItems() / 2)
+		n.items.insertAt(i, item)
+		n.children.insertAt(i+1, second)
+	case len(child.items) < t.minItems():
+		if i == len(n.items) {
+			i--
+		}
+		t.splicer().fixPair(n, i)
+	}
+}
+
+// SetMergeOperator registers fn as the merge operator of t, used by Merge.
+// Clones of t taken afterwards share it.
+func (t *BTree) SetMergeOperator(fn MergeFunc) {
+	t.cow.merge = fn
+}
+
+// Merge combines operand with the value of k using the merge operator of the
+// tree, in a single descent, and stores the result.  It panics if no merge
+// operator was registered with SetMergeOperator.
+func (t *BTree) Merge(k, operand []byte) {
+	merge := t.cow.merge
+	if merge == nil {
+		panic("bytebtree: Merge on a tree without a merge operator")
+	}
+	t.Upsert(k, func(old []byte, exists bool) ([]byte, bool) {
+		return merge(k, old, exists, operand), true
+	})
+}
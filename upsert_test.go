@@ -0,0 +1,199 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestUpsert(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, opts := range []Options{{Degree: 2}, {Degree: 3}, {Degree: 8}, {Degree: 3, CompressKeys: true}, {Degree: 3, ArenaChunkSize: 64}} {
+		t.Run(fmt.Sprintf("degree=%d/compress=%v/arena=%v", opts.Degree, opts.CompressKeys, opts.ArenaChunkSize > 0), func(t *testing.T) {
+			tr := NewWithOptions(opts)
+			want := make(map[string]string)
+			var clone *BTree
+			var cloned map[string]string
+			for i := 0; i < 5000; i++ {
+				if i == 2500 {
+					clone = tr.Clone()
+					cloned = contents(clone)
+				}
+				k := seqKey(r.Intn(300))
+				op := r.Intn(3)
+				v := []byte(fmt.Sprint(i))
+				old, exists := want[string(k)]
+				tr.Upsert(k, func(gotOld []byte, gotExists bool) ([]byte, bool) {
+					if gotExists != exists || string(gotOld) != old {
+						t.Fatalf("key %x: fn got %q, %v, want %q, %v", k, gotOld, gotExists, old, exists)
+					}
+					switch op {
+					case 0:
+						return nil, false
+					case 1:
+						return v, true
+					}
+					// Keep the current value, if any.
+					return gotOld, gotExists
+				})
+				switch {
+				case op == 0:
+					delete(want, string(k))
+				case op == 1:
+					want[string(k)] = string(v)
+				}
+			}
+			checkContents(t, tr, want)
+			checkContents(t, clone, cloned)
+			if opts.CompressKeys {
+				checkPacked(t, tr)
+			}
+			if a := tr.cow.arena; a != nil {
+				live := 0
+				for k, v := range want {
+					live += len(k) + len(v)
+				}
+				if a.live != live {
+					t.Fatalf("arena holds %d live bytes, want %d", a.live, live)
+				}
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tr := NewWithOptions(Options{Degree: 3, CopyItems: true})
+	tr.SetMergeOperator(func(key, old []byte, exists bool, operand []byte) []byte {
+		var n uint64
+		if exists {
+			n = binary.BigEndian.Uint64(old)
+		}
+		return binary.BigEndian.AppendUint64(nil, n+binary.BigEndian.Uint64(operand))
+	})
+	one := binary.BigEndian.AppendUint64(nil, 1)
+	for i := 0; i < 100; i++ {
+		for j := 0; j <= i; j++ {
+			tr.Merge(seqKey(j), one)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		v, _ := tr.Get(seqKey(i))
+		if n := binary.BigEndian.Uint64(v); n != uint64(100-i) {
+			t.Fatalf("key %d: got count %d, want %d", i, n, 100-i)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Merge without a merge operator didn't panic")
+		}
+	}()
+	New().Merge(seqKey(0), one)
+}
+
+func BenchmarkUpsert(b *testing.B) {
+	const size = 100_000
+	incr := func(old []byte) []byte {
+		var n uint64
+		if old != nil {
+			n = binary.BigEndian.Uint64(old)
+		}
+		return binary.BigEndian.AppendUint64(old[:0], n+1)
+	}
+	b.Run("GetReplaceOrInsert", func(b *testing.B) {
+		tr := New()
+		for i := 0; i < b.N; i++ {
+			k := seqKey(i % size)
+			v, _ := tr.Get(k)
+			tr.ReplaceOrInsert(k, incr(v))
+		}
+	})
+	b.Run("Upsert", func(b *testing.B) {
+		tr := New()
+		for i := 0; i < b.N; i++ {
+			tr.Upsert(seqKey(i%size), func(old []byte, exists bool) ([]byte, bool) {
+				return incr(old), true
+			})
+		}
+	})
+}
+
+func TestConditionalWrites(t *testing.T) {
+	for _, opts := range []Options{{Degree: 2}, {Degree: 3, CompressKeys: true}, {Degree: 3, ArenaChunkSize: 64}} {
+		tr := NewWithOptions(opts)
+		for i := 0; i < 100; i++ {
+			if _, ok := tr.InsertIfAbsent(seqKey(i), []byte("a")); !ok {
+				t.Fatalf("InsertIfAbsent of new key %d failed", i)
+			}
+		}
+		clone := tr.Clone()
+		root := tr.root
+
+		// Failed writes leave a tree sharing its nodes alone.
+		if v, ok := tr.InsertIfAbsent(seqKey(5), []byte("b")); ok || string(v) != "a" {
+			t.Fatalf("InsertIfAbsent of existing key: got %q, %v", v, ok)
+		}
+		if tr.CompareAndSwap(seqKey(5), []byte("b"), []byte("c")) {
+			t.Fatalf("CompareAndSwap with the wrong value succeeded")
+		}
+		if tr.CompareAndSwap(seqKey(100), nil, []byte("c")) {
+			t.Fatalf("CompareAndSwap of a missing key succeeded")
+		}
+		if tr.CompareAndDelete(seqKey(5), []byte("b")) {
+			t.Fatalf("CompareAndDelete with the wrong value succeeded")
+		}
+		if tr.CompareAndDelete(seqKey(100), nil) {
+			t.Fatalf("CompareAndDelete of a missing key succeeded")
+		}
+		if tr.root != root {
+			t.Fatalf("failed writes copied the root")
+		}
+
+		for i := 0; i < 100; i += 2 {
+			if !tr.CompareAndSwap(seqKey(i), []byte("a"), []byte("b")) {
+				t.Fatalf("CompareAndSwap of key %d failed", i)
+			}
+		}
+		for i := 0; i < 100; i += 3 {
+			if !tr.CompareAndDelete(seqKey(i), []byte("b")) != (i%2 != 0) {
+				t.Fatalf("CompareAndDelete of key %d: wrong result", i)
+			}
+		}
+		want := make(map[string]string)
+		for i := 0; i < 100; i++ {
+			switch {
+			case i%2 == 0 && i%3 == 0:
+			case i%2 == 0:
+				want[string(seqKey(i))] = "b"
+			default:
+				want[string(seqKey(i))] = "a"
+			}
+		}
+		checkContents(t, tr, want)
+		checkSeq(t, clone, 0, 100)
+		clone.Ascend(func(k, v []byte) bool {
+			if string(v) != "a" {
+				t.Fatalf("clone key %x holds %q", k, v)
+			}
+			return true
+		})
+		if opts.CompressKeys {
+			checkPacked(t, tr)
+		}
+	}
+}
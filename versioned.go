@@ -0,0 +1,57 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import "sync/atomic"
+
+// Versioned pairs a tree written by a single goroutine with the last version
+// of it that was published to readers.
+//
+// The writer makes its changes to Writer() and calls Publish to make them
+// visible.  Readers call Snapshot, which never blocks nor waits for the
+// writer: the published versions are snapshots that share every node the
+// writer hasn't modified since.
+type Versioned struct {
+	w         *BTree
+	published atomic.Value // *ReadOnlyBTree
+}
+
+// NewVersioned returns a Versioned whose writer tree is t, with the current
+// contents of t published.  t must only be used by the writer afterwards.
+func NewVersioned(t *BTree) *Versioned {
+	v := &Versioned{w: t}
+	v.Publish()
+	return v
+}
+
+// Writer returns the tree that the writer modifies.  It must only be used by
+// one goroutine at a time, and its changes are invisible to readers until the
+// next call to Publish.
+func (v *Versioned) Writer() *BTree {
+	return v.w
+}
+
+// Publish makes the current contents of the writer tree the version returned
+// by Snapshot.  It must only be called by the writer.
+func (v *Versioned) Publish() {
+	v.published.Store(v.w.Snapshot())
+}
+
+// Snapshot returns the last published version.  It is safe to call from any
+// number of goroutines, concurrently with the writer.  The version is shared
+// by all its readers, which may each Clone it to get a tree they can write.
+func (v *Versioned) Snapshot() *ReadOnlyBTree {
+	return v.published.Load().(*ReadOnlyBTree)
+}
@@ -0,0 +1,105 @@
+// Copyright 2014 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytebtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+// TestVersioned runs one writer against several readers; run it with -race.
+func TestVersioned(t *testing.T) {
+	const batch, batches = 10, 300
+	for _, opts := range []Options{{Degree: 3}, {Degree: 3, ArenaChunkSize: 256}} {
+		v := NewVersioned(NewWithOptions(opts))
+		if n := v.Snapshot().Len(); n != 0 {
+			t.Fatalf("initial snapshot holds %d items", n)
+		}
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		for r := 0; r < 8; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				last := 0
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					// The writer publishes after each batch, having deleted the
+					// batch before the previous one: a snapshot always holds
+					// whole batches, in a single contiguous run.
+					snap := v.Snapshot()
+					n := snap.Len()
+					if n%batch != 0 {
+						t.Errorf("snapshot holds %d items", n)
+						return
+					}
+					max, _ := snap.Max()
+					if max != nil && bytes.Compare(max, seqKey(last)) < 0 {
+						t.Errorf("snapshot went back in time: max %x", max)
+						return
+					}
+					i := 0
+					var first []byte
+					snap.Ascend(func(k, _ []byte) bool {
+						if first == nil {
+							first = k
+						} else if want := seqKey(keyIndex(first) + i); !bytes.Equal(k, want) {
+							t.Errorf("snapshot holds %x, want %x", k, want)
+							return false
+						}
+						i++
+						return true
+					})
+					if max != nil {
+						last = keyIndex(max)
+					}
+
+					// Readers clone the version they share to write to it.
+					c := snap.Clone()
+					c.ReplaceOrInsert(seqKey(batches*batch), nil)
+					c.DeleteMin()
+					if c.Len() != n || snap.Len() != n || snap.Has(seqKey(batches*batch)) {
+						t.Errorf("clone holds %d items, snapshot %d, want %d", c.Len(), snap.Len(), n)
+						return
+					}
+				}
+			}()
+		}
+		w := v.Writer()
+		for b := 0; b < batches; b++ {
+			for i := b * batch; i < (b+1)*batch; i++ {
+				w.ReplaceOrInsert(seqKey(i), nil)
+			}
+			if b >= 2 {
+				w.DeleteRange(seqKey((b-2)*batch), seqKey((b-1)*batch))
+			}
+			v.Publish()
+		}
+		close(done)
+		wg.Wait()
+		checkSeq(t, v.Snapshot().Clone(), (batches-2)*batch, batches*batch)
+	}
+}
+
+// keyIndex returns i for a key made by seqKey(i).
+func keyIndex(k []byte) int {
+	return int(binary.BigEndian.Uint32(k))
+}